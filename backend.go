@@ -0,0 +1,61 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// backend is a clipboard implementation. Calls are serialized by the package
+// lock, except for the goroutines started by write, restore and watch, and
+// for flush, which takes the lock itself rather than hold it while waiting.
+type backend interface {
+	// supports reports whether the platform has the selection. The other
+	// methods are only called with supported selections.
+	supports(sel Selection) bool
+
+	read(sel Selection, f Format) ([]byte, error)
+	readAny(sel Selection, formats []Format) (Format, Entry, error)
+	write(sel Selection, f Format, buf []byte) (<-chan struct{}, error)
+	watch(ctx context.Context, sel Selection, f Format) (<-chan []byte, error)
+	snapshot(sel Selection) (*Contents, error)
+	restore(sel Selection, c *Contents) (<-chan struct{}, error)
+	flush(ctx context.Context) (bool, error)
+}
+
+// pollWatch implements watch for backends without change notifications by
+// reading the selection every second.
+func pollWatch(ctx context.Context, sel Selection, t Format, read func(Selection, Format) ([]byte, error)) <-chan []byte {
+	recv := make(chan []byte, 1)
+	ticker := time.NewTicker(time.Second)
+	last, _ := read(sel, t)
+
+	go func() {
+		defer ticker.Stop()
+		defer close(recv)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				b, _ := read(sel, t)
+				if b == nil {
+					continue
+				}
+				if !bytes.Equal(last, b) {
+					select {
+					case recv <- b:
+					case <-ctx.Done():
+						return
+					}
+					last = b
+				}
+			}
+		}
+	}()
+
+	return recv
+}
@@ -1,7 +1,8 @@
 // Package nativeclipboard provides cross-platform clipboard access using purego
 // instead of cgo. It supports text and image data on macOS, Linux, and Windows.
 //
-// The package initializes automatically and returns errors from individual operations.
+// The package detects its backend on first use and returns errors from
+// individual operations. Call [Init] to detect it up front instead.
 //
 // Read and write clipboard data:
 //
@@ -47,56 +48,114 @@ const (
 
 var (
 	// Due to platform limitations, concurrent reads can cause issues.
-	// Use a global lock to guarantee one operation at a time.
-	lock      = sync.Mutex{}
-	initError error
+	// Use a global lock to guarantee one operation at a time, across all
+	// clipboards.
+	lock = sync.Mutex{}
+
+	// std is the clipboard used by the package level API. It is created on
+	// first use, and stdReady is closed once it is.
+	std      *Clipboard
+	stdOnce  sync.Once
+	stdReady = make(chan struct{})
 )
 
-func init() {
-	initError = initialize()
+// defaultClipboard returns the clipboard used by the package level API,
+// detecting its backend on first use.
+func defaultClipboard() *Clipboard {
+	stdOnce.Do(func() {
+		std = newClipboard()
+		close(stdReady)
+	})
+	return std
+}
+
+// Init detects the backend of the package level API and returns the error
+// that operations would otherwise report.
+//
+// Calling Init is optional: the backend is detected on first use. Call it
+// early to pay for detection up front, such as loading libX11 and
+// connecting to the display, and to check whether the clipboard works. If
+// ctx is done before detection finishes, Init returns the context error and
+// detection carries on in the background.
+func Init(ctx context.Context) error {
+	go defaultClipboard()
+
+	select {
+	case <-stdReady:
+		return std.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // Read reads clipboard data in this format.
 // Returns an error if the clipboard is unavailable or initialization failed.
 func (f Format) Read() ([]byte, error) {
-	if initError != nil {
-		return nil, initError
-	}
-
-	lock.Lock()
-	defer lock.Unlock()
-
-	buf, err := read(f)
-	if err != nil {
-		return nil, err
-	}
-	return buf, nil
+	return ClipboardSelection.Read(f)
 }
 
 // Write writes data to the clipboard in this format.
 // Returns a channel that receives a signal when the clipboard content
 // has been overwritten by another application, and an error if the operation fails.
 func (f Format) Write(buf []byte) (<-chan struct{}, error) {
-	if initError != nil {
-		return nil, initError
-	}
-
-	lock.Lock()
-	defer lock.Unlock()
-
-	changed, err := write(f, buf)
-	if err != nil {
-		return nil, err
-	}
-	return changed, nil
+	return ClipboardSelection.Write(f, buf)
 }
 
 // Watch returns a channel that receives clipboard data whenever it changes.
 // The channel will be closed when the provided context is canceled.
 // Returns an error if the clipboard is unavailable or initialization failed.
+//
+// On Wayland, watching requires a compositor supporting one of the data
+// control protocols, and ErrUnsupported is returned otherwise.
 func (f Format) Watch(ctx context.Context) (<-chan []byte, error) {
-	if initError != nil {
-		return nil, initError
-	}
-	return watch(ctx, f), nil
+	return ClipboardSelection.Watch(ctx, f)
+}
+
+// ReadAny reads the first of the given formats that the clipboard currently
+// offers, in order of preference, and reports which one was used. The check
+// and the read happen in a single locked operation, so the returned data
+// always matches the returned format.
+//
+// It returns ErrUnavailable if none of the formats are offered.
+func ReadAny(formats ...Format) (Format, []byte, error) {
+	return ClipboardSelection.ReadAny(formats...)
+}
+
+// ReadAnyEntry is like [ReadAny] but also reports the native target the data
+// was read from, such as "UTF8_STRING" on X11 or "CF_DIB" on Windows. The
+// entry data is converted to the returned format just like [Format.Read]
+// would.
+func ReadAnyEntry(formats ...Format) (Format, Entry, error) {
+	return ClipboardSelection.ReadAnyEntry(formats...)
+}
+
+// Flush makes sure the clipboard contents written by this process outlive
+// it, and reports whether they will.
+//
+// On X11, the data written with [Format.Write] is served by this process and
+// disappears when it exits. Flush asks a running clipboard manager to take a
+// copy using the freedesktop clipboard manager protocol, and returns true once
+// the manager confirms it saved the contents. It returns false if no manager
+// is running, the manager refused, or this process doesn't own the
+// clipboard. Use ctx to bound how long to wait for the manager; without a
+// deadline, Flush gives up after 5 seconds. Other clipboard calls don't wait
+// for Flush.
+//
+// On macOS and Windows the system keeps the clipboard contents, and Flush
+// always returns true.
+func Flush(ctx context.Context) (bool, error) {
+	return defaultClipboard().Flush(ctx)
+}
+
+// Backend reports which backend the package level API uses, and why the
+// backends tried before it were rejected.
+//
+// Backends are detected automatically, in order: Wayland, X11, external
+// clipboard tools and the terminal on Linux and BSD, the system clipboard on
+// macOS and Windows, and finally an in-memory clipboard private to the
+// process. Set NATIVECLIPBOARD_BACKEND to a comma separated list of backend
+// names, such as "x11,osc52", to try those instead, or use [New] with
+// [WithBackend].
+func Backend() Detection {
+	return defaultClipboard().Detection()
 }
@@ -0,0 +1,504 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// commandTool describes an external program giving access to the
+// selections. Argument functions return the full command line.
+type commandTool struct {
+	name string
+	// env is the environment variable that must be set for the tool to
+	// work, such as $DISPLAY.
+	env string
+	// programs lists the executables the tool needs.
+	programs []string
+	// clipboardOnly reports whether the tool lacks a primary selection.
+	clipboardOnly bool
+	// emptyClears reports whether the tool ignores empty data, so writing
+	// it clears the selection instead.
+	emptyClears bool
+
+	// targets returns the targets used for a format, in order of
+	// preference.
+	targets func(f Format) ([]string, error)
+
+	readArgs  func(sel Selection, target string) []string
+	writeArgs func(sel Selection, target string) []string
+	clearArgs func(sel Selection) []string
+	// listArgs lists the targets offered by the selection, one per line.
+	// It is nil if the tool can't list targets.
+	listArgs func(sel Selection) []string
+	// watchArgs prints a line every time the selection changes. It is nil
+	// if the tool can't watch the selection.
+	watchArgs func(sel Selection) []string
+}
+
+// wlClipboard uses wl-copy and wl-paste from wl-clipboard.
+var wlClipboard = commandTool{
+	name:     "wl-clipboard",
+	env:      "WAYLAND_DISPLAY",
+	programs: []string{"wl-copy", "wl-paste"},
+	targets:  mimeTypes,
+	readArgs: func(sel Selection, target string) []string {
+		return wlArgs(sel, "wl-paste", "--no-newline", "--type", target)
+	},
+	writeArgs: func(sel Selection, target string) []string {
+		return wlArgs(sel, "wl-copy", "--type", target)
+	},
+	clearArgs: func(sel Selection) []string {
+		return wlArgs(sel, "wl-copy", "--clear")
+	},
+	listArgs: func(sel Selection) []string {
+		return wlArgs(sel, "wl-paste", "--list-types")
+	},
+	watchArgs: func(sel Selection) []string {
+		return wlArgs(sel, "wl-paste", "--watch", "echo")
+	},
+}
+
+func wlArgs(sel Selection, program string, args ...string) []string {
+	if sel == PrimarySelection {
+		args = append([]string{"--primary"}, args...)
+	}
+	return append([]string{program}, args...)
+}
+
+// xclip uses xclip.
+var xclip = commandTool{
+	name:     "xclip",
+	env:      "DISPLAY",
+	programs: []string{"xclip"},
+	targets: func(f Format) ([]string, error) {
+		target, err := formatTarget(f)
+		return []string{target}, err
+	},
+	readArgs: func(sel Selection, target string) []string {
+		return []string{"xclip", "-selection", xclipSelection(sel), "-out", "-target", target}
+	},
+	writeArgs: func(sel Selection, target string) []string {
+		return []string{"xclip", "-selection", xclipSelection(sel), "-in", "-target", target}
+	},
+	clearArgs: func(sel Selection) []string {
+		return []string{"xclip", "-selection", xclipSelection(sel), "-in", "/dev/null"}
+	},
+	listArgs: func(sel Selection) []string {
+		return []string{"xclip", "-selection", xclipSelection(sel), "-out", "-target", "TARGETS"}
+	},
+}
+
+func xclipSelection(sel Selection) string {
+	if sel == PrimarySelection {
+		return "primary"
+	}
+	return "clipboard"
+}
+
+// xsel uses xsel, which only handles text.
+var xsel = commandTool{
+	name:     "xsel",
+	env:      "DISPLAY",
+	programs: []string{"xsel"},
+	targets: func(f Format) ([]string, error) {
+		if f != Text {
+			return nil, ErrUnsupported
+		}
+		return []string{"UTF8_STRING"}, nil
+	},
+	readArgs: func(sel Selection, _ string) []string {
+		return []string{"xsel", xselSelection(sel), "--output"}
+	},
+	writeArgs: func(sel Selection, _ string) []string {
+		return []string{"xsel", xselSelection(sel), "--input"}
+	},
+	clearArgs: func(sel Selection) []string {
+		return []string{"xsel", xselSelection(sel), "--clear"}
+	},
+}
+
+func xselSelection(sel Selection) string {
+	if sel == PrimarySelection {
+		return "--primary"
+	}
+	return "--clipboard"
+}
+
+// tmuxBuffers uses the paste buffers of tmux, which hold text and have no
+// primary selection. Writes go to a new buffer, and reads return the most
+// recent one. tmux doesn't create empty buffers, so writing nothing deletes
+// the most recent buffer instead. It isn't part of commandTools, as the
+// buffers are private to tmux: it is only used when selected by name.
+var tmuxBuffers = commandTool{
+	name:          "tmux",
+	env:           "TMUX",
+	programs:      []string{"tmux"},
+	clipboardOnly: true,
+	emptyClears:   true,
+	targets: func(f Format) ([]string, error) {
+		if f != Text {
+			return nil, ErrUnsupported
+		}
+		return []string{"text/plain;charset=utf-8"}, nil
+	},
+	readArgs: func(Selection, string) []string {
+		return []string{"tmux", "save-buffer", "-"}
+	},
+	writeArgs: func(Selection, string) []string {
+		return []string{"tmux", "load-buffer", "-"}
+	},
+	clearArgs: func(Selection) []string {
+		return []string{"tmux", "delete-buffer"}
+	},
+}
+
+// commandTools lists the supported tools in order of preference.
+var commandTools = []*commandTool{&wlClipboard, &xclip, &xsel}
+
+// commandBackend implements the clipboard by running an external tool such
+// as wl-copy or xclip. It is used when the display can't be reached
+// directly, for example when libX11 is missing.
+//
+// The tools serve the data they copy from a background process, so written
+// contents outlive this process. Change notifications for writes are
+// detected by polling.
+type commandBackend struct {
+	tool *commandTool
+}
+
+// newCommandBackend picks the first tool that is installed and whose
+// display is available.
+func newCommandBackend() (backend, error) {
+	for _, tool := range commandTools {
+		if b, err := newToolBackend(tool); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: none of wl-clipboard, xclip or xsel is available", ErrUnavailable)
+}
+
+// newToolBackend uses the given tool, if it is installed and its display is
+// available.
+func newToolBackend(tool *commandTool) (backend, error) {
+	if os.Getenv(tool.env) == "" {
+		return nil, fmt.Errorf("%w: %s: $%s is not set", ErrUnavailable, tool.name, tool.env)
+	}
+	if !installed(tool.programs) {
+		return nil, fmt.Errorf("%w: %s is not installed", ErrUnavailable, tool.name)
+	}
+	return commandBackend{tool: tool}, nil
+}
+
+// installed reports whether all programs are in $PATH.
+func installed(programs []string) bool {
+	for _, p := range programs {
+		if _, err := exec.LookPath(p); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// run runs a tool command line, feeding it stdin if not nil, and returns
+// its output.
+func (b commandBackend) run(args []string, stdin []byte) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s: %s", ErrUnavailable, b.tool.name, msg)
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrUnavailable, b.tool.name, err)
+	}
+	return out, nil
+}
+
+// start runs a tool command line that copies stdin to the selection. The
+// tools fork a process serving the selection, which would keep our output
+// pipes open, so its output is discarded. The process is put in its own
+// session to survive us.
+func (b commandBackend) start(args []string, stdin []byte) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrUnavailable, b.tool.name, err)
+	}
+	return nil
+}
+
+// offered returns the targets offered by the selection, or nil if the tool
+// can't list them.
+func (b commandBackend) offered(sel Selection) (map[string]bool, []string, error) {
+	if b.tool.listArgs == nil {
+		return nil, nil, nil
+	}
+	out, err := b.run(b.tool.listArgs(sel), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	targets := strings.Fields(string(out))
+	set := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		set[t] = true
+	}
+	return set, targets, nil
+}
+
+func (b commandBackend) supports(sel Selection) bool {
+	return sel == ClipboardSelection || !b.tool.clipboardOnly
+}
+
+func (b commandBackend) read(sel Selection, t Format) ([]byte, error) {
+	_, e, err := b.readAny(sel, []Format{t})
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+func (b commandBackend) readAny(sel Selection, formats []Format) (Format, Entry, error) {
+	targets := make([][]string, len(formats))
+	unsupported := 0
+	for i, f := range formats {
+		t, err := b.tool.targets(f)
+		if err != nil {
+			// The tool can't carry f, try the other formats.
+			unsupported++
+			continue
+		}
+		targets[i] = t
+	}
+	if len(formats) > 0 && unsupported == len(formats) {
+		return 0, Entry{}, fmt.Errorf("%w: %s can't read any of the formats", ErrUnsupported, b.tool.name)
+	}
+
+	offered, _, err := b.offered(sel)
+	if err != nil {
+		return 0, Entry{}, err
+	}
+
+	for i, f := range formats {
+		for _, target := range targets[i] {
+			if offered != nil && !offered[target] {
+				continue
+			}
+			data, err := b.run(b.tool.readArgs(sel, target), nil)
+			if err != nil {
+				continue
+			}
+			return f, Entry{Target: target, Data: data}, nil
+		}
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
+func (b commandBackend) write(sel Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	targets, err := b.tool.targets(t)
+	if err != nil {
+		return nil, err
+	}
+	return b.own(sel, Entry{Target: targets[0], Data: buf})
+}
+
+// commandWrites counts the writes made to each selection, so that the
+// watchers of earlier writes stop.
+var commandWrites [PrimarySelection + 1]atomic.Uint64
+
+// own copies e to the selection, and watches for it being replaced, by
+// another application or by a later write. Changes are reported by the watch
+// command of the tool, or found by polling for tools that can't watch.
+func (b commandBackend) own(sel Selection, e Entry) (<-chan struct{}, error) {
+	if len(e.Data) == 0 && b.tool.emptyClears {
+		return b.clear(sel)
+	}
+	if err := b.start(b.tool.writeArgs(sel, e.Target), e.Data); err != nil {
+		return nil, err
+	}
+	write := commandWrites[sel].Add(1)
+
+	replaced := func() bool {
+		if commandWrites[sel].Load() != write {
+			return true
+		}
+		data, err := b.run(b.tool.readArgs(sel, e.Target), nil)
+		return err != nil || !bytes.Equal(data, e.Data)
+	}
+
+	var events *bufio.Scanner
+	ctx, cancel := context.WithCancel(context.Background())
+	if b.tool.watchArgs != nil {
+		args := b.tool.watchArgs(sel)
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		if out, err := cmd.StdoutPipe(); err == nil && cmd.Start() == nil {
+			events = bufio.NewScanner(out)
+			go func() {
+				<-ctx.Done()
+				cmd.Wait()
+			}()
+		}
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer cancel()
+
+		if events != nil {
+			for events.Scan() {
+				if replaced() {
+					changed <- struct{}{}
+					close(changed)
+					return
+				}
+			}
+			// The watch command died, poll instead.
+			cancel()
+		}
+		for {
+			time.Sleep(time.Second)
+			if replaced() {
+				changed <- struct{}{}
+				close(changed)
+				return
+			}
+		}
+	}()
+	return changed, nil
+}
+
+// clear clears the selection. Nothing is left to be replaced, so the
+// returned channel never fires.
+func (b commandBackend) clear(sel Selection) (<-chan struct{}, error) {
+	if err := b.start(b.tool.clearArgs(sel), nil); err != nil {
+		return nil, err
+	}
+	commandWrites[sel].Add(1)
+	return make(chan struct{}, 1), nil
+}
+
+// watch runs the watch command of the tool and reads the selection every
+// time it reports a change. Tools that can't watch are polled.
+func (b commandBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
+	if b.tool.watchArgs == nil {
+		return pollWatch(ctx, sel, t, b.read), nil
+	}
+
+	args := b.tool.watchArgs(sel)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrUnavailable, b.tool.name, err)
+	}
+
+	recv := make(chan []byte, 1)
+	last, _ := b.read(sel, t)
+
+	go func() {
+		defer close(recv)
+		defer cmd.Wait()
+
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			data, _ := b.read(sel, t)
+			if data == nil || bytes.Equal(last, data) {
+				continue
+			}
+			last = data
+
+			select {
+			case recv <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return recv, nil
+}
+
+// snapshot reads every target the tool lists, or text for tools that can't
+// list targets.
+func (b commandBackend) snapshot(sel Selection) (*Contents, error) {
+	contents := &Contents{}
+
+	_, targets, err := b.offered(sel)
+	if err != nil {
+		// The tools fail when the selection is empty.
+		return contents, nil
+	}
+	if targets == nil {
+		targets, _ = b.tool.targets(Text)
+	}
+
+	for _, target := range targets {
+		if metaTargets[target] {
+			continue
+		}
+		data, err := b.run(b.tool.readArgs(sel, target), nil)
+		if err != nil {
+			continue
+		}
+		contents.Entries = append(contents.Entries, Entry{Target: target, Data: data})
+	}
+	return contents, nil
+}
+
+// restore copies a single entry of c, as the tools only offer a single
+// target: the preferred text or image entry, or the only entry holding
+// data. Empty contents clear the selection.
+func (b commandBackend) restore(sel Selection, c *Contents) (<-chan struct{}, error) {
+	var entries []Entry
+	for _, e := range c.Entries {
+		if !metaTargets[e.Target] {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return b.clear(sel)
+	}
+	if len(entries) == 1 {
+		return b.own(sel, entries[0])
+	}
+
+	for _, f := range []Format{Text, Image} {
+		targets, _ := b.tool.targets(f)
+		if f == Text {
+			targets = append(targets, "UTF8_STRING", "text/plain;charset=utf-8")
+		}
+		for _, target := range targets {
+			for _, e := range entries {
+				if e.Target == target {
+					return b.own(sel, e)
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s can only restore one of %d entries", ErrUnsupported, b.tool.name, len(entries))
+}
+
+// flush reports true, the tools keep serving the contents after we exit.
+func (commandBackend) flush(ctx context.Context) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,463 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeToolScripts are minimal stand-ins for the clipboard tools. They keep
+// each selection in a directory under $FAKE_CLIPBOARD holding its data, its
+// type and a generation number bumped on every copy.
+var fakeToolScripts = map[string]string{
+	"wl-copy": `#!/bin/sh
+sel=clipboard type=text/plain clear=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--primary) sel=primary ;;
+	--type) shift; type=$1 ;;
+	--clear) clear=1 ;;
+	esac
+	shift
+done
+dir=$FAKE_CLIPBOARD/$sel
+mkdir -p "$dir"
+if [ -n "$clear" ]; then
+	rm -f "$dir/type" "$dir/data"
+else
+	cat > "$dir/data"
+	echo "$type" > "$dir/type"
+fi
+echo $$ > "$dir/gen"
+`,
+	"wl-paste": `#!/bin/sh
+sel=clipboard mode=read type=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--primary) sel=primary ;;
+	--list-types) mode=list ;;
+	--type) shift; type=$1 ;;
+	--watch) mode=watch ;;
+	esac
+	shift
+done
+dir=$FAKE_CLIPBOARD/$sel
+if [ $mode = watch ]; then
+	gen=
+	while :; do
+		g=$(cat "$dir/gen" 2>/dev/null)
+		if [ "$g" != "$gen" ]; then
+			gen=$g
+			echo
+		fi
+		sleep 0.05
+	done
+fi
+if [ ! -f "$dir/type" ]; then
+	echo "Nothing is copied" >&2
+	exit 1
+fi
+if [ $mode = list ]; then
+	cat "$dir/type"
+	exit 0
+fi
+if [ -n "$type" ] && [ "$type" != "$(cat "$dir/type")" ]; then
+	echo "No suitable type of content copied" >&2
+	exit 1
+fi
+cat "$dir/data"
+`,
+	"xclip": `#!/bin/sh
+sel=primary mode=in target=STRING file=
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-selection) shift; sel=$1 ;;
+	-in) mode=in ;;
+	-out) mode=out ;;
+	-target) shift; target=$1 ;;
+	*) file=$1 ;;
+	esac
+	shift
+done
+dir=$FAKE_CLIPBOARD/$sel
+mkdir -p "$dir"
+if [ $mode = in ]; then
+	cat $file > "$dir/data"
+	echo "$target" > "$dir/type"
+	echo $$ > "$dir/gen"
+	exit 0
+fi
+if [ ! -f "$dir/type" ]; then
+	echo "Error: target $target not available" >&2
+	exit 1
+fi
+if [ "$target" = TARGETS ]; then
+	echo TARGETS
+	cat "$dir/type"
+	exit 0
+fi
+if [ "$target" != "$(cat "$dir/type")" ]; then
+	echo "Error: target $target not available" >&2
+	exit 1
+fi
+cat "$dir/data"
+`,
+	"tmux": `#!/bin/sh
+dir=$FAKE_CLIPBOARD/buffers
+mkdir -p "$dir"
+case "$1" in
+load-buffer)
+	data=$(cat; echo x)
+	[ "$data" != x ] && printf %s "${data%x}" > "$dir/$(date +%s%N)"
+	;;
+save-buffer)
+	top=$(ls "$dir" | tail -n 1)
+	if [ -z "$top" ]; then
+		echo "no buffers" >&2
+		exit 1
+	fi
+	cat "$dir/$top"
+	;;
+delete-buffer)
+	top=$(ls "$dir" | tail -n 1)
+	[ -n "$top" ] && rm "$dir/$top"
+	;;
+esac
+exit 0
+`,
+}
+
+// installFakeTools puts the given fake tools first in $PATH for the
+// duration of the test.
+func installFakeTools(t *testing.T, names ...string) {
+	t.Helper()
+
+	bin := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(fakeToolScripts[name]), 0o755); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_CLIPBOARD", t.TempDir())
+}
+
+func newTestCommandBackend(t *testing.T, tool string) backend {
+	t.Helper()
+
+	b, err := newCommandBackend()
+	if err != nil {
+		t.Fatalf("newCommandBackend failed: %v", err)
+	}
+	if name := b.(commandBackend).tool.name; name != tool {
+		t.Fatalf("Expected %s to be picked, got %s", tool, name)
+	}
+	return b
+}
+
+func TestCommandWriteRead(t *testing.T) {
+	for _, tc := range []struct {
+		tool  string
+		env   string
+		names []string
+	}{
+		{"wl-clipboard", "WAYLAND_DISPLAY", []string{"wl-copy", "wl-paste"}},
+		{"xclip", "DISPLAY", []string{"xclip"}},
+	} {
+		t.Run(tc.tool, func(t *testing.T) {
+			installFakeTools(t, tc.names...)
+			t.Setenv("WAYLAND_DISPLAY", "")
+			t.Setenv("DISPLAY", "")
+			t.Setenv(tc.env, "fake")
+			b := newTestCommandBackend(t, tc.tool)
+
+			if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+				t.Fatalf("Expected ErrUnavailable from an empty clipboard, got %v", err)
+			}
+
+			if _, err := b.write(ClipboardSelection, Text, []byte("Hello, tools!")); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			if _, err := b.write(PrimarySelection, Image, []byte("not really a png")); err != nil {
+				t.Fatalf("write to primary failed: %v", err)
+			}
+
+			f, e, err := b.readAny(ClipboardSelection, []Format{Image, Text})
+			if err != nil {
+				t.Fatalf("readAny failed: %v", err)
+			}
+			if f != Text || string(e.Data) != "Hello, tools!" {
+				t.Fatalf("Unexpected result: %v %q %q", f, e.Target, e.Data)
+			}
+
+			data, err := b.read(PrimarySelection, Image)
+			if err != nil {
+				t.Fatalf("read from primary failed: %v", err)
+			}
+			if string(data) != "not really a png" {
+				t.Fatalf("Expected %q, got %q", "not really a png", data)
+			}
+
+			c, err := b.snapshot(ClipboardSelection)
+			if err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			if len(c.Entries) != 1 || string(c.Entries[0].Data) != "Hello, tools!" {
+				t.Fatalf("Unexpected snapshot: %+v", c.Entries)
+			}
+		})
+	}
+}
+
+func TestCommandChanged(t *testing.T) {
+	installFakeTools(t, "wl-copy", "wl-paste")
+	t.Setenv("WAYLAND_DISPLAY", "fake")
+	b := newTestCommandBackend(t, "wl-clipboard")
+
+	changed, err := b.write(ClipboardSelection, Text, []byte("First"))
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// Another application copies something.
+	cmd := exec.Command("wl-copy", "--type", "text/plain")
+	cmd.Stdin = strings.NewReader("Second")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("wl-copy failed: %v", err)
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for change notification")
+	}
+}
+
+func TestCommandChangedByUs(t *testing.T) {
+	for _, tc := range []struct {
+		tool  string
+		env   string
+		names []string
+	}{
+		{"wl-clipboard", "WAYLAND_DISPLAY", []string{"wl-copy", "wl-paste"}},
+		{"xclip", "DISPLAY", []string{"xclip"}},
+	} {
+		t.Run(tc.tool, func(t *testing.T) {
+			installFakeTools(t, tc.names...)
+			t.Setenv("WAYLAND_DISPLAY", "")
+			t.Setenv("DISPLAY", "")
+			t.Setenv(tc.env, "fake")
+			b := newTestCommandBackend(t, tc.tool)
+
+			changed, err := b.write(ClipboardSelection, Text, []byte("Same"))
+			if err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			// Writing the same data again still replaces the first write.
+			if _, err := b.write(ClipboardSelection, Text, []byte("Same")); err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+
+			select {
+			case <-changed:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Timeout waiting for change notification")
+			}
+		})
+	}
+}
+
+func TestCommandRestore(t *testing.T) {
+	installFakeTools(t, "wl-copy", "wl-paste")
+	t.Setenv("WAYLAND_DISPLAY", "fake")
+	b := newTestCommandBackend(t, "wl-clipboard")
+
+	tests := []struct {
+		name    string
+		entries []Entry
+		want    Entry // the zero value expects ErrUnsupported
+	}{
+		{"single", []Entry{
+			{Target: "application/x-custom", Data: []byte("custom")},
+		}, Entry{Target: "application/x-custom", Data: []byte("custom")}},
+		{"meta targets", []Entry{
+			{Target: "TARGETS", Data: []byte("atoms")},
+			{Target: "TEXT", Data: []byte("compound")},
+			{Target: "UTF8_STRING", Data: []byte("text")},
+		}, Entry{Target: "UTF8_STRING", Data: []byte("text")}},
+		{"preferred text", []Entry{
+			{Target: "image/png", Data: []byte("png")},
+			{Target: "STRING", Data: []byte("latin-1")},
+			{Target: "text/plain;charset=utf-8", Data: []byte("text")},
+		}, Entry{Target: "text/plain;charset=utf-8", Data: []byte("text")}},
+		{"image", []Entry{
+			{Target: "application/x-custom", Data: []byte("custom")},
+			{Target: "image/png", Data: []byte("png")},
+		}, Entry{Target: "image/png", Data: []byte("png")}},
+		{"ambiguous", []Entry{
+			{Target: "application/x-a", Data: []byte("a")},
+			{Target: "application/x-b", Data: []byte("b")},
+		}, Entry{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := b.restore(ClipboardSelection, &Contents{Entries: tc.entries})
+			if tc.want.Target == "" {
+				if !errors.Is(err, ErrUnsupported) {
+					t.Fatalf("Expected ErrUnsupported, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("restore failed: %v", err)
+			}
+			c, err := b.snapshot(ClipboardSelection)
+			if err != nil {
+				t.Fatalf("snapshot failed: %v", err)
+			}
+			if len(c.Entries) != 1 || c.Entries[0].Target != tc.want.Target || string(c.Entries[0].Data) != string(tc.want.Data) {
+				t.Fatalf("Expected %+v, got %+v", tc.want, c.Entries)
+			}
+		})
+	}
+}
+
+func TestCommandWatch(t *testing.T) {
+	installFakeTools(t, "wl-copy", "wl-paste")
+	t.Setenv("WAYLAND_DISPLAY", "fake")
+	b := newTestCommandBackend(t, "wl-clipboard")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch, err := b.watch(ctx, ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	if _, err := b.write(ClipboardSelection, Text, []byte("Watch test")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	select {
+	case data := <-ch:
+		if string(data) != "Watch test" {
+			t.Fatalf("Expected %q, got %q", "Watch test", data)
+		}
+	case <-ctx.Done():
+		t.Fatal("Timeout waiting for clipboard change")
+	}
+
+	cancel()
+	for range ch {
+	}
+}
+
+func TestCommandTemporary(t *testing.T) {
+	installFakeTools(t, "xclip")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("DISPLAY", "fake")
+	c, err := New(WithBackend("command"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := c.Write(ClipboardSelection, Text, []byte("Original")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := c.WithTemporary(context.Background(), ClipboardSelection, Text, []byte("Temporary"), func() error {
+		return nil
+	}); err != nil {
+		t.Fatalf("WithTemporary failed: %v", err)
+	}
+	if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "Original" {
+		t.Fatalf("Expected %q after restoring, got %q (%v)", "Original", data, err)
+	}
+
+	// Another application copying right before fn returns wins, long
+	// before polling would notice.
+	if err := c.WithTemporary(context.Background(), ClipboardSelection, Text, []byte("Temporary"), func() error {
+		cmd := exec.Command("xclip", "-selection", "clipboard", "-in", "-target", "UTF8_STRING")
+		cmd.Stdin = strings.NewReader("Newer")
+		return cmd.Run()
+	}); err != nil {
+		t.Fatalf("WithTemporary failed: %v", err)
+	}
+	if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "Newer" {
+		t.Fatalf("Expected %q to be kept, got %q (%v)", "Newer", data, err)
+	}
+}
+
+func TestCommandTmux(t *testing.T) {
+	installFakeTools(t, "tmux")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("DISPLAY", "")
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1,0")
+	if _, err := newCommandBackend(); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected tmux not to be detected, got %v", err)
+	}
+	b, err := newToolBackend(&tmuxBuffers)
+	if err != nil {
+		t.Fatalf("newToolBackend failed: %v", err)
+	}
+
+	if b.supports(PrimarySelection) {
+		t.Fatal("Expected tmux buffers not to support the primary selection")
+	}
+	if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable without buffers, got %v", err)
+	}
+	if _, err := b.write(ClipboardSelection, Image, []byte("png")); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported writing an image, got %v", err)
+	}
+
+	for _, text := range []string{"First buffer", "Second buffer"} {
+		if _, err := b.write(ClipboardSelection, Text, []byte(text)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	data, err := b.read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "Second buffer" {
+		t.Fatalf("Expected %q, got %q", "Second buffer", data)
+	}
+	f, e, err := b.readAny(ClipboardSelection, []Format{Image, Text})
+	if err != nil {
+		t.Fatalf("readAny failed: %v", err)
+	}
+	if f != Text || string(e.Data) != "Second buffer" {
+		t.Fatalf("Unexpected result: %v %q %q", f, e.Target, e.Data)
+	}
+	if _, _, err := b.readAny(ClipboardSelection, []Format{Image}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported reading an image, got %v", err)
+	}
+
+	// tmux ignores empty buffers, writing nothing deletes ours instead.
+	if _, err := b.write(ClipboardSelection, Text, nil); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	data, err = b.read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "First buffer" {
+		t.Fatalf("Expected %q, got %q", "First buffer", data)
+	}
+}
+
+func TestCommandUnavailable(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	t.Setenv("WAYLAND_DISPLAY", "fake")
+	t.Setenv("DISPLAY", "fake")
+	if _, err := newCommandBackend(); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable, got %v", err)
+	}
+}
@@ -17,8 +17,10 @@ import (
 
 var (
 	// Classes
-	nsPasteboardClass objc.Class
-	nsDataClass       objc.Class
+	nsPasteboardClass   objc.Class
+	nsDataClass         objc.Class
+	nsStringClass       objc.Class
+	nsMutableArrayClass objc.Class
 
 	// Selectors
 	sel_generalPasteboard    objc.SEL
@@ -29,25 +31,44 @@ var (
 	sel_dataWithBytes_length objc.SEL
 	sel_bytes                objc.SEL
 	sel_length               objc.SEL
+	sel_types                objc.SEL
+	sel_declareTypes_owner   objc.SEL
+	sel_count                objc.SEL
+	sel_objectAtIndex        objc.SEL
+	sel_array                objc.SEL
+	sel_addObject            objc.SEL
+	sel_stringWithUTF8String objc.SEL
+	sel_UTF8String           objc.SEL
 
 	// Pasteboard types (NSString constants)
 	NSPasteboardTypeString objc.ID
 	NSPasteboardTypePNG    objc.ID
 )
 
-func initialize() error {
+// darwinBackend implements the clipboard on top of NSPasteboard.
+type darwinBackend struct{}
+
+// backends lists the backends that can be selected by name.
+var backends = []backendFactory{{"macos", ignoreOptions(newMacOSBackend)}}
+
+// autoBackends is the automatic detection chain.
+var autoBackends = backends
+
+func newMacOSBackend() (backend, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
 	// Load AppKit framework for NSPasteboard
 	appkit, err := purego.Dlopen("/System/Library/Frameworks/AppKit.framework/AppKit", purego.RTLD_NOW|purego.RTLD_GLOBAL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Get classes
 	nsPasteboardClass = objc.GetClass("NSPasteboard")
 	nsDataClass = objc.GetClass("NSData")
+	nsStringClass = objc.GetClass("NSString")
+	nsMutableArrayClass = objc.GetClass("NSMutableArray")
 
 	// Register selectors
 	sel_generalPasteboard = objc.RegisterName("generalPasteboard")
@@ -58,26 +79,34 @@ func initialize() error {
 	sel_dataWithBytes_length = objc.RegisterName("dataWithBytes:length:")
 	sel_bytes = objc.RegisterName("bytes")
 	sel_length = objc.RegisterName("length")
+	sel_types = objc.RegisterName("types")
+	sel_declareTypes_owner = objc.RegisterName("declareTypes:owner:")
+	sel_count = objc.RegisterName("count")
+	sel_objectAtIndex = objc.RegisterName("objectAtIndex:")
+	sel_array = objc.RegisterName("array")
+	sel_addObject = objc.RegisterName("addObject:")
+	sel_stringWithUTF8String = objc.RegisterName("stringWithUTF8String:")
+	sel_UTF8String = objc.RegisterName("UTF8String")
 
 	// Get pasteboard type constants from AppKit
 	// NSPasteboardTypeString and NSPasteboardTypePNG are NSString constants
 	typeStringPtr, err := purego.Dlsym(appkit, "NSPasteboardTypeString")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	typePNGPtr, err := purego.Dlsym(appkit, "NSPasteboardTypePNG")
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Dereference the pointers to get the actual NSString objects
 	NSPasteboardTypeString = objc.ID(*(*uintptr)(unsafe.Pointer(typeStringPtr)))
 	NSPasteboardTypePNG = objc.ID(*(*uintptr)(unsafe.Pointer(typePNGPtr)))
 
-	return nil
+	return darwinBackend{}, nil
 }
 
-func read(t Format) ([]byte, error) {
+func (darwinBackend) read(_ Selection, t Format) ([]byte, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -123,7 +152,48 @@ func read(t Format) ([]byte, error) {
 	return result, nil
 }
 
-func write(t Format, buf []byte) (<-chan struct{}, error) {
+func (darwinBackend) readAny(_ Selection, formats []Format) (Format, Entry, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	pasteboard := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
+	if pasteboard == 0 {
+		return 0, Entry{}, ErrUnavailable
+	}
+
+	for _, f := range formats {
+		var pasteboardType objc.ID
+		switch f {
+		case Text:
+			pasteboardType = NSPasteboardTypeString
+		case Image:
+			pasteboardType = NSPasteboardTypePNG
+		default:
+			return 0, Entry{}, ErrUnsupported
+		}
+
+		// Get data: [pasteboard dataForType:type]
+		data := pasteboard.Send(sel_dataForType, pasteboardType)
+		if data == 0 {
+			continue
+		}
+
+		length := objc.Send[uint64](data, sel_length)
+		buf := make([]byte, length)
+		if length > 0 {
+			copyBytes(buf, uintptr(data.Send(sel_bytes)), int(length))
+		}
+
+		return f, Entry{
+			Target: goString(objc.Send[*byte](pasteboardType, sel_UTF8String)),
+			Data:   buf,
+		}, nil
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
+func (darwinBackend) write(_ Selection, t Format, buf []byte) (<-chan struct{}, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -170,35 +240,18 @@ func write(t Format, buf []byte) (<-chan struct{}, error) {
 	}
 
 	// Monitor for changes
-	changed := make(chan struct{}, 1)
-	initialCount := objc.Send[int64](pasteboard, sel_changeCount)
-
-	go func() {
-		for {
-			time.Sleep(time.Second)
-			pb := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
-			if pb == 0 {
-				continue
-			}
-			count := objc.Send[int64](pb, sel_changeCount)
-			if count != initialCount {
-				changed <- struct{}{}
-				close(changed)
-				return
-			}
-		}
-	}()
-
-	return changed, nil
+	return watchChangeCount(pasteboard), nil
 }
 
-func watch(ctx context.Context, t Format) <-chan []byte {
+func (d darwinBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
 	recv := make(chan []byte, 1)
 	ticker := time.NewTicker(time.Second)
 
 	go func() {
 		runtime.LockOSThread()
 		defer runtime.UnlockOSThread()
+		defer ticker.Stop()
+		defer close(recv)
 
 		pasteboard := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
 		lastCount := objc.Send[int64](pasteboard, sel_changeCount)
@@ -206,16 +259,18 @@ func watch(ctx context.Context, t Format) <-chan []byte {
 		for {
 			select {
 			case <-ctx.Done():
-				ticker.Stop()
-				close(recv)
 				return
 			case <-ticker.C:
 				pb := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
 				currentCount := objc.Send[int64](pb, sel_changeCount)
 				if currentCount != lastCount {
-					b, _ := read(t)
+					b, _ := d.read(sel, t)
 					if b != nil {
-						recv <- b
+						select {
+						case recv <- b:
+						case <-ctx.Done():
+							return
+						}
 					}
 					lastCount = currentCount
 				}
@@ -223,7 +278,116 @@ func watch(ctx context.Context, t Format) <-chan []byte {
 		}
 	}()
 
-	return recv
+	return recv, nil
+}
+
+func (darwinBackend) snapshot(Selection) (*Contents, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	pasteboard := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
+	if pasteboard == 0 {
+		return nil, ErrUnavailable
+	}
+
+	contents := &Contents{}
+
+	// Get all offered types: [pasteboard types]
+	types := pasteboard.Send(sel_types)
+	if types == 0 {
+		return contents, nil
+	}
+
+	count := objc.Send[uint64](types, sel_count)
+	for i := uint64(0); i < count; i++ {
+		typ := types.Send(sel_objectAtIndex, i)
+		data := pasteboard.Send(sel_dataForType, typ)
+		if data == 0 {
+			continue
+		}
+
+		length := objc.Send[uint64](data, sel_length)
+		buf := make([]byte, length)
+		if length > 0 {
+			copyBytes(buf, uintptr(data.Send(sel_bytes)), int(length))
+		}
+
+		contents.Entries = append(contents.Entries, Entry{
+			Target: goString(objc.Send[*byte](typ, sel_UTF8String)),
+			Data:   buf,
+		})
+	}
+
+	return contents, nil
+}
+
+func (darwinBackend) restore(_ Selection, c *Contents) (<-chan struct{}, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	pasteboard := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
+	if pasteboard == 0 {
+		return nil, ErrUnavailable
+	}
+
+	// Declare all types at once: [pasteboard declareTypes:types owner:nil]
+	types := objc.ID(nsMutableArrayClass).Send(sel_array)
+	typeIDs := make([]objc.ID, len(c.Entries))
+	for i, e := range c.Entries {
+		name := append([]byte(e.Target), 0)
+		typeIDs[i] = objc.ID(nsStringClass).Send(sel_stringWithUTF8String, unsafe.Pointer(&name[0]))
+		types.Send(sel_addObject, typeIDs[i])
+	}
+	pasteboard.Send(sel_declareTypes_owner, types, objc.ID(0))
+
+	for i, e := range c.Entries {
+		data := objc.ID(nsDataClass).Send(sel_dataWithBytes_length, unsafe.Pointer(unsafe.SliceData(e.Data)), uint64(len(e.Data)))
+		if data == 0 {
+			return nil, ErrUnavailable
+		}
+		if !objc.Send[bool](pasteboard, sel_setData_forType, data, typeIDs[i]) {
+			return nil, ErrUnavailable
+		}
+	}
+
+	return watchChangeCount(pasteboard), nil
+}
+
+// watchChangeCount returns a channel that is signaled once the pasteboard
+// change count moves past its current value.
+func watchChangeCount(pasteboard objc.ID) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	initialCount := objc.Send[int64](pasteboard, sel_changeCount)
+
+	go func() {
+		for {
+			time.Sleep(time.Second)
+			pb := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
+			if pb == 0 {
+				continue
+			}
+			count := objc.Send[int64](pb, sel_changeCount)
+			if count != initialCount {
+				changed <- struct{}{}
+				close(changed)
+				return
+			}
+		}
+	}()
+
+	return changed
+}
+
+// goString copies a NUL-terminated C string into a Go string.
+func goString(p *byte) string {
+	if p == nil {
+		return ""
+	}
+	var n int
+	for *(*byte)(unsafe.Add(unsafe.Pointer(p), n)) != 0 {
+		n++
+	}
+	return string(unsafe.Slice(p, n))
 }
 
 func copyBytes(dst []byte, src uintptr, length int) {
@@ -234,3 +398,24 @@ func copyBytes(dst []byte, src uintptr, length int) {
 	srcSlice := unsafe.Slice((*byte)(unsafe.Pointer(src)), length)
 	copy(dst, srcSlice)
 }
+
+// flush reports success right away, the pasteboard server keeps a copy of
+// everything we write.
+func (darwinBackend) flush(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// changeCount returns the pasteboard change count.
+func (darwinBackend) changeCount(Selection) (uint64, error) {
+	pasteboard := objc.ID(nsPasteboardClass).Send(sel_generalPasteboard)
+	if pasteboard == 0 {
+		return 0, ErrUnavailable
+	}
+	return uint64(objc.Send[int64](pasteboard, sel_changeCount)), nil
+}
+
+// supports reports whether sel is the clipboard, macOS has no primary
+// selection.
+func (darwinBackend) supports(sel Selection) bool {
+	return sel == ClipboardSelection
+}
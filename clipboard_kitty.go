@@ -0,0 +1,339 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// kittyBackend implements the clipboard with kitty's clipboard protocol, the
+// OSC 5522 escape sequence, which carries any MIME type.
+type kittyBackend struct {
+	term terminal
+}
+
+// kittyTimeout bounds how long we wait for the terminal to answer a
+// request.
+const kittyTimeout = 5 * time.Second
+
+// kittyChunkSize is the amount of data sent per packet.
+const kittyChunkSize = 3072
+
+// kittyList is the MIME type requesting the list of available types.
+const kittyList = "."
+
+// newKittyBackend makes sure the controlling terminal can be opened.
+func newKittyBackend() (backend, error) {
+	t := newTerminal()
+	f, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return kittyBackend{term: t}, nil
+}
+
+// kittyMimeTypes returns the MIME types used for a format, in order of
+// preference.
+func kittyMimeTypes(t Format) ([]string, error) {
+	switch t {
+	case Text:
+		return []string{"text/plain", "text/plain;charset=utf-8", "UTF8_STRING"}, nil
+	case Image:
+		return []string{"image/png"}, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// kittyPacket is a decoded OSC 5522 packet.
+type kittyPacket struct {
+	meta    map[string]string
+	payload []byte
+}
+
+// kittyEncode encodes a packet of the form
+//
+//	OSC 5522 ; key=value:key=value ; base64 payload ST
+//
+// Values of the mime key are base64 encoded too.
+func kittyEncode(payload []byte, meta ...string) string {
+	var b strings.Builder
+	b.WriteString("\x1b]5522;")
+	for i := 0; i+1 < len(meta); i += 2 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		v := meta[i+1]
+		if meta[i] == "mime" {
+			v = base64.StdEncoding.EncodeToString([]byte(v))
+		}
+		b.WriteString(meta[i] + "=" + v)
+	}
+	if payload != nil {
+		b.WriteByte(';')
+		b.WriteString(base64.StdEncoding.EncodeToString(payload))
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// kittyDecode returns all complete packets in buf.
+func kittyDecode(buf []byte) []kittyPacket {
+	var packets []kittyPacket
+	for {
+		i := bytes.Index(buf, []byte("\x1b]5522;"))
+		if i < 0 {
+			return packets
+		}
+		buf = buf[i+len("\x1b]5522;"):]
+		end, size := bytes.IndexByte(buf, '\a'), 1
+		if st := bytes.Index(buf, []byte("\x1b\\")); st >= 0 && (end < 0 || st < end) {
+			end, size = st, 2
+		}
+		if end < 0 {
+			return packets
+		}
+		body := buf[:end]
+		buf = buf[end+size:]
+
+		p := kittyPacket{meta: make(map[string]string)}
+		meta, payload, _ := bytes.Cut(body, []byte(";"))
+		for _, kv := range strings.Split(string(meta), ":") {
+			k, v, _ := strings.Cut(kv, "=")
+			if k == "mime" {
+				if dec, err := base64.StdEncoding.DecodeString(v); err == nil {
+					v = string(dec)
+				}
+			}
+			p.meta[k] = v
+		}
+		p.payload, _ = base64.StdEncoding.DecodeString(string(payload))
+		packets = append(packets, p)
+	}
+}
+
+// kittyStatusError converts an error status to an error. It returns nil for
+// other statuses.
+func kittyStatusError(status string) error {
+	switch {
+	case status == "ENOSYS":
+		return fmt.Errorf("%w: terminal doesn't support the request", ErrUnsupported)
+	case status == "EPERM":
+		return fmt.Errorf("%w: terminal denied clipboard access", ErrUnavailable)
+	case strings.HasPrefix(status, "E"):
+		return fmt.Errorf("%w: terminal reported %s", ErrUnavailable, status)
+	}
+	return nil
+}
+
+// kittyFinished returns a function reporting whether a reply holds the
+// final packet of a request of type typ.
+func kittyFinished(typ string) func([]byte) bool {
+	return func(buf []byte) bool {
+		for _, p := range kittyDecode(buf) {
+			if p.meta["type"] != typ {
+				continue
+			}
+			if s := p.meta["status"]; s == "DONE" || kittyStatusError(s) != nil {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// kittyLocation returns the metadata selecting sel.
+func kittyLocation(sel Selection) []string {
+	if sel == PrimarySelection {
+		return []string{"loc", "primary"}
+	}
+	return nil
+}
+
+func (kittyBackend) supports(Selection) bool {
+	return true
+}
+
+// request reads the given MIME types from sel and returns their data, keyed
+// by MIME type, along with the order they were sent in.
+func (b kittyBackend) request(sel Selection, mimes []string) (map[string][]byte, []string, error) {
+	meta := append([]string{"type", "read"}, kittyLocation(sel)...)
+	seq := kittyEncode([]byte(strings.Join(mimes, " ")), meta...)
+	reply, err := b.term.query(seq, kittyTimeout, kittyFinished("read"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data := make(map[string][]byte)
+	var order []string
+	for _, p := range kittyDecode(reply) {
+		if p.meta["type"] != "read" {
+			continue
+		}
+		if err := kittyStatusError(p.meta["status"]); err != nil {
+			return nil, nil, err
+		}
+		if p.meta["status"] != "DATA" {
+			continue
+		}
+		mime := p.meta["mime"]
+		if _, ok := data[mime]; !ok {
+			order = append(order, mime)
+		}
+		data[mime] = append(data[mime], p.payload...)
+	}
+	return data, order, nil
+}
+
+// available returns the MIME types held by sel.
+func (b kittyBackend) available(sel Selection) ([]string, error) {
+	data, _, err := b.request(sel, []string{kittyList})
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data[kittyList])), nil
+}
+
+func (b kittyBackend) read(sel Selection, t Format) ([]byte, error) {
+	_, e, err := b.readAny(sel, []Format{t})
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+func (b kittyBackend) readAny(sel Selection, formats []Format) (Format, Entry, error) {
+	mimes := make([][]string, len(formats))
+	unsupported := 0
+	for i, f := range formats {
+		m, err := kittyMimeTypes(f)
+		if err != nil {
+			unsupported++
+			continue
+		}
+		mimes[i] = m
+	}
+	if len(formats) > 0 && unsupported == len(formats) {
+		return 0, Entry{}, fmt.Errorf("%w: none of the formats can be read", ErrUnsupported)
+	}
+
+	types, err := b.available(sel)
+	if err != nil {
+		return 0, Entry{}, err
+	}
+	offered := make(map[string]bool, len(types))
+	for _, m := range types {
+		offered[m] = true
+	}
+
+	for i, f := range formats {
+		for _, mime := range mimes[i] {
+			if !offered[mime] {
+				continue
+			}
+			data, _, err := b.request(sel, []string{mime})
+			if err != nil {
+				return 0, Entry{}, err
+			}
+			if buf, ok := data[mime]; ok {
+				return f, Entry{Target: mime, Data: buf}, nil
+			}
+		}
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
+// send replaces sel with the given entries.
+func (b kittyBackend) send(sel Selection, entries []Entry) error {
+	var seq strings.Builder
+	seq.WriteString(kittyEncode(nil, append([]string{"type", "write"}, kittyLocation(sel)...)...))
+	for _, e := range entries {
+		data := e.Data
+		for {
+			n := min(len(data), kittyChunkSize)
+			seq.WriteString(kittyEncode(data[:n], "type", "wdata", "mime", e.Target))
+			data = data[n:]
+			if len(data) == 0 {
+				break
+			}
+		}
+	}
+	seq.WriteString(kittyEncode(nil, "type", "wdata"))
+
+	reply, err := b.term.query(seq.String(), kittyTimeout, kittyFinished("write"))
+	if err != nil {
+		return err
+	}
+	for _, p := range kittyDecode(reply) {
+		if p.meta["type"] == "write" {
+			if err := kittyStatusError(p.meta["status"]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// write sends the data to the terminal. The returned channel never fires,
+// since the terminal doesn't report changes.
+func (b kittyBackend) write(sel Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	mimes, err := kittyMimeTypes(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.send(sel, []Entry{{Target: mimes[0], Data: buf}}); err != nil {
+		return nil, err
+	}
+	return make(chan struct{}, 1), nil
+}
+
+func (kittyBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
+	return nil, fmt.Errorf("%w: terminals don't report clipboard changes", ErrUnsupported)
+}
+
+func (b kittyBackend) snapshot(sel Selection) (*Contents, error) {
+	types, err := b.available(sel)
+	if err != nil {
+		return nil, err
+	}
+	contents := &Contents{}
+	if len(types) == 0 {
+		return contents, nil
+	}
+
+	data, order, err := b.request(sel, types)
+	if err != nil {
+		return nil, err
+	}
+	for _, mime := range order {
+		contents.Entries = append(contents.Entries, Entry{Target: mime, Data: data[mime]})
+	}
+	return contents, nil
+}
+
+func (b kittyBackend) restore(sel Selection, c *Contents) (<-chan struct{}, error) {
+	if err := b.send(sel, c.Entries); err != nil {
+		return nil, err
+	}
+	return make(chan struct{}, 1), nil
+}
+
+// flush reports true, the terminal keeps the clipboard.
+func (kittyBackend) flush(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// changeCount fails, the terminal doesn't report changes.
+func (kittyBackend) changeCount(Selection) (uint64, error) {
+	return 0, fmt.Errorf("%w: the terminal doesn't report clipboard changes", ErrUnsupported)
+}
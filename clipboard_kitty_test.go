@@ -0,0 +1,241 @@
+//go:build linux && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeKitty implements the clipboard side of OSC 5522 for a fake terminal.
+type fakeKitty struct {
+	mu sync.Mutex
+	// deny makes the terminal refuse all requests.
+	deny bool
+	// selections holds the entries of each location, "" being the
+	// clipboard.
+	selections map[string][]Entry
+	// pending holds the entries of a write in progress and its location.
+	pending    []Entry
+	pendingLoc string
+	// chunks counts the wdata packets received.
+	chunks int
+}
+
+func newKittyTerminal(t *testing.T) (*fakeTerminal, *fakeKitty) {
+	k := &fakeKitty{selections: make(map[string][]Entry)}
+	return newFakeTerminal(t, k.handle), k
+}
+
+func (k *fakeKitty) handle(osc string) string {
+	if !strings.HasPrefix(osc, "5522;") {
+		return ""
+	}
+	packets := kittyDecode([]byte("\x1b]" + osc + "\x1b\\"))
+	if len(packets) != 1 {
+		return ""
+	}
+	p := packets[0]
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	switch p.meta["type"] {
+	case "read":
+		if k.deny {
+			return kittyEncode(nil, "type", "read", "status", "EPERM")
+		}
+		entries := k.selections[p.meta["loc"]]
+		var reply strings.Builder
+		for _, mime := range strings.Fields(string(p.payload)) {
+			if mime == kittyList {
+				var types []string
+				for _, e := range entries {
+					types = append(types, e.Target)
+				}
+				reply.WriteString(kittyEncode([]byte(strings.Join(types, " ")), "type", "read", "status", "DATA", "mime", kittyList))
+				continue
+			}
+			for _, e := range entries {
+				if e.Target != mime {
+					continue
+				}
+				// Send the data in small chunks to exercise reassembly.
+				for data := e.Data; len(data) > 0; {
+					n := min(len(data), 1000)
+					reply.WriteString(kittyEncode(data[:n], "type", "read", "status", "DATA", "mime", mime))
+					data = data[n:]
+				}
+			}
+		}
+		reply.WriteString(kittyEncode(nil, "type", "read", "status", "DONE"))
+		return reply.String()
+	case "write":
+		k.pending, k.pendingLoc = nil, p.meta["loc"]
+	case "wdata":
+		mime, ok := p.meta["mime"]
+		if !ok {
+			if k.deny {
+				return kittyEncode(nil, "type", "write", "status", "EPERM")
+			}
+			k.selections[k.pendingLoc] = k.pending
+			return kittyEncode(nil, "type", "write", "status", "DONE")
+		}
+		k.chunks++
+		if n := len(k.pending); n > 0 && k.pending[n-1].Target == mime {
+			k.pending[n-1].Data = append(k.pending[n-1].Data, p.payload...)
+		} else {
+			k.pending = append(k.pending, Entry{Target: mime, Data: p.payload})
+		}
+	}
+	return ""
+}
+
+func TestKittyWriteRead(t *testing.T) {
+	ft, k := newKittyTerminal(t)
+	b := kittyBackend{term: terminal{path: ft.path}}
+
+	if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable from an empty clipboard, got %v", err)
+	}
+
+	if _, err := b.write(ClipboardSelection, Text, []byte("Hello, kitty!")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	png := bytes.Repeat([]byte("\x89PNG"), 2000)
+	if _, err := b.write(PrimarySelection, Image, png); err != nil {
+		t.Fatalf("write to primary failed: %v", err)
+	}
+
+	data, err := b.read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "Hello, kitty!" {
+		t.Fatalf("Expected %q, got %q", "Hello, kitty!", data)
+	}
+
+	f, e, err := b.readAny(PrimarySelection, []Format{Format(-1), Image, Text})
+	if err != nil {
+		t.Fatalf("readAny failed: %v", err)
+	}
+	if f != Image || e.Target != "image/png" || !bytes.Equal(e.Data, png) {
+		t.Fatalf("Unexpected result: %v %q (%d bytes)", f, e.Target, len(e.Data))
+	}
+
+	k.mu.Lock()
+	chunks := k.chunks
+	k.mu.Unlock()
+	if want := 1 + (len(png)+kittyChunkSize-1)/kittyChunkSize; chunks != want {
+		t.Fatalf("Expected %d chunks, got %d", want, chunks)
+	}
+}
+
+func TestKittySnapshotRestore(t *testing.T) {
+	ft, _ := newKittyTerminal(t)
+	b := kittyBackend{term: terminal{path: ft.path}}
+
+	want := &Contents{Entries: []Entry{
+		{Target: "text/html", Data: []byte("<b>bold</b>")},
+		{Target: "text/plain", Data: []byte("bold")},
+		{Target: "application/x-custom", Data: bytes.Repeat([]byte{0, 1, 2}, 3000)},
+	}}
+	if _, err := b.restore(ClipboardSelection, want); err != nil {
+		t.Fatalf("restore failed: %v", err)
+	}
+
+	got, err := b.snapshot(ClipboardSelection)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if len(got.Entries) != len(want.Entries) {
+		t.Fatalf("Expected %d entries, got %d", len(want.Entries), len(got.Entries))
+	}
+	for i, e := range want.Entries {
+		if got.Entries[i].Target != e.Target || !bytes.Equal(got.Entries[i].Data, e.Data) {
+			t.Fatalf("Entry %d: expected %q, got %q", i, e.Target, got.Entries[i].Target)
+		}
+	}
+
+	empty, err := b.snapshot(PrimarySelection)
+	if err != nil {
+		t.Fatalf("snapshot of primary failed: %v", err)
+	}
+	if len(empty.Entries) != 0 {
+		t.Fatalf("Expected an empty snapshot, got %+v", empty.Entries)
+	}
+}
+
+func TestKittyDenied(t *testing.T) {
+	ft, k := newKittyTerminal(t)
+	k.deny = true
+	b := kittyBackend{term: terminal{path: ft.path}}
+
+	if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable reading, got %v", err)
+	}
+	if _, err := b.write(ClipboardSelection, Text, []byte("denied")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable writing, got %v", err)
+	}
+}
+
+func TestTerminalProbe(t *testing.T) {
+	kitty, _ := newKittyTerminal(t)
+	if !(terminal{path: kitty.path}).supportsKitty() {
+		t.Fatal("Expected kitty support to be detected")
+	}
+
+	osc52, _ := newOSC52Terminal(t)
+	if (terminal{path: osc52.path}).supportsKitty() {
+		t.Fatal("Expected no kitty support")
+	}
+}
+
+func TestTerminalProbeTmux(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		passthrough string
+		want        bool
+	}{
+		{"off", "off", false},
+		{"on", "on", true},
+		{"before 3.3", "", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bin := t.TempDir()
+			script := "#!/bin/sh\necho '" + tc.passthrough + "'\n"
+			if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte(script), 0o755); err != nil {
+				t.Fatalf("Failed to write tmux: %v", err)
+			}
+			t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+			kitty, _ := newKittyTerminal(t)
+			if got := (terminal{path: kitty.path, mux: tmuxMultiplexer}).supportsKitty(); got != tc.want {
+				t.Fatalf("Expected %v with allow-passthrough %q, got %v", tc.want, tc.passthrough, got)
+			}
+		})
+	}
+}
+
+func TestKittyEncodeDecode(t *testing.T) {
+	seq := kittyEncode([]byte("payload"), "type", "read", "mime", "text/plain")
+	if want := "\x1b]5522;type=read:mime=dGV4dC9wbGFpbg==;cGF5bG9hZA==\x1b\\"; seq != want {
+		t.Fatalf("Expected %q, got %q", want, seq)
+	}
+
+	packets := kittyDecode([]byte("junk" + seq + "\x1b]5522;type=read:status=DONE\a\x1b]5522;partial"))
+	if len(packets) != 2 {
+		t.Fatalf("Expected 2 packets, got %d", len(packets))
+	}
+	if p := packets[0]; p.meta["mime"] != "text/plain" || string(p.payload) != "payload" {
+		t.Fatalf("Unexpected packet: %+v", p)
+	}
+	if p := packets[1]; p.meta["status"] != "DONE" || len(p.payload) != 0 {
+		t.Fatalf("Unexpected packet: %+v", p)
+	}
+}
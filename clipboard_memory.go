@@ -0,0 +1,130 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBackend keeps the selections in memory. It ends the automatic
+// detection chain, so copy and paste keep working within the process when
+// no system clipboard can be reached, for example in containers and CI.
+type memoryBackend struct {
+	// mu guards the selections, which watch reads from its own goroutine.
+	mu         sync.Mutex
+	selections [PrimarySelection + 1]memorySelection
+}
+
+type memorySelection struct {
+	entries []Entry
+	// changed fires when the entries are replaced.
+	changed chan struct{}
+	// count is incremented whenever the entries are replaced.
+	count uint64
+}
+
+func newMemoryBackend() (backend, error) {
+	return &memoryBackend{}, nil
+}
+
+// memoryTarget returns the target name used for a format.
+func memoryTarget(t Format) (string, error) {
+	switch t {
+	case Text:
+		return "text/plain;charset=utf-8", nil
+	case Image:
+		return "image/png", nil
+	default:
+		return "", ErrUnsupported
+	}
+}
+
+// supports reports true, both selections are kept.
+func (*memoryBackend) supports(Selection) bool {
+	return true
+}
+
+func (b *memoryBackend) read(sel Selection, t Format) ([]byte, error) {
+	_, e, err := b.readAny(sel, []Format{t})
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+func (b *memoryBackend) readAny(sel Selection, formats []Format) (Format, Entry, error) {
+	for _, f := range formats {
+		if _, err := memoryTarget(f); err != nil {
+			return 0, Entry{}, err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, f := range formats {
+		for _, e := range b.selections[sel].entries {
+			if f == Text && textTargets[e.Target] || f == Image && e.Target == "image/png" {
+				return f, Entry{Target: e.Target, Data: append([]byte(nil), e.Data...)}, nil
+			}
+		}
+	}
+	return 0, Entry{}, ErrUnavailable
+}
+
+func (b *memoryBackend) write(sel Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	target, err := memoryTarget(t)
+	if err != nil {
+		return nil, err
+	}
+	return b.restore(sel, &Contents{Entries: []Entry{{Target: target, Data: buf}}})
+}
+
+func (b *memoryBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
+	return pollWatch(ctx, sel, t, b.read), nil
+}
+
+func (b *memoryBackend) snapshot(sel Selection) (*Contents, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	contents := &Contents{}
+	for _, e := range b.selections[sel].entries {
+		contents.Entries = append(contents.Entries, Entry{Target: e.Target, Data: append([]byte(nil), e.Data...)})
+	}
+	return contents, nil
+}
+
+// restore replaces the entries of sel and signals the previous writer.
+func (b *memoryBackend) restore(sel Selection, c *Contents) (<-chan struct{}, error) {
+	entries := make([]Entry, len(c.Entries))
+	for i, e := range c.Entries {
+		entries[i] = Entry{Target: e.Target, Data: append([]byte(nil), e.Data...)}
+	}
+	changed := make(chan struct{}, 1)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if prev := b.selections[sel].changed; prev != nil {
+		prev <- struct{}{}
+		close(prev)
+	}
+	b.selections[sel] = memorySelection{entries: entries, changed: changed, count: b.selections[sel].count + 1}
+	return changed, nil
+}
+
+// changeCount returns the number of times sel was written.
+func (b *memoryBackend) changeCount(sel Selection) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.selections[sel].count, nil
+}
+
+// flush reports false, the contents are lost when the process exits.
+func (*memoryBackend) flush(ctx context.Context) (bool, error) {
+	return false, nil
+}
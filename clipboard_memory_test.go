@@ -0,0 +1,63 @@
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryWriteRead(t *testing.T) {
+	c, err := New(WithBackend("memory"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := c.Read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable from an empty clipboard, got %v", err)
+	}
+
+	changed, err := c.Write(ClipboardSelection, Text, []byte("In memory"))
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := c.Write(PrimarySelection, Image, []byte("png")); err != nil {
+		t.Fatalf("Write to primary failed: %v", err)
+	}
+
+	f, data, err := c.ReadAny(ClipboardSelection, Image, Text)
+	if err != nil {
+		t.Fatalf("ReadAny failed: %v", err)
+	}
+	if f != Text || string(data) != "In memory" {
+		t.Fatalf("Unexpected result: %v %q", f, data)
+	}
+	if data, err := c.Read(PrimarySelection, Image); err != nil || string(data) != "png" {
+		t.Fatalf("Unexpected primary contents: %q, %v", data, err)
+	}
+
+	select {
+	case <-changed:
+		t.Fatal("Unexpected change notification")
+	default:
+	}
+	if _, err := c.Restore(ClipboardSelection, &Contents{Entries: []Entry{{Target: "UTF8_STRING", Data: []byte("Restored")}}}); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for change notification")
+	}
+
+	snap, err := c.Snapshot(ClipboardSelection)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snap.Entries) != 1 || snap.Entries[0].Target != "UTF8_STRING" || string(snap.Entries[0].Data) != "Restored" {
+		t.Fatalf("Unexpected snapshot: %+v", snap.Entries)
+	}
+	if ok, err := c.Flush(context.Background()); ok || err != nil {
+		t.Fatalf("Expected Flush to report false, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// osc52Backend implements the clipboard with the OSC 52 escape sequence,
+// which asks the terminal emulator to set or report its clipboard. It only
+// carries text.
+type osc52Backend struct {
+	term terminal
+}
+
+// osc52Timeout bounds how long we wait for the terminal to report the
+// clipboard.
+const osc52Timeout = 2 * time.Second
+
+// osc52Target is the target name used for text in snapshots.
+const osc52Target = "text/plain;charset=utf-8"
+
+// newOSC52Backend makes sure the controlling terminal can be opened.
+func newOSC52Backend() (backend, error) {
+	t := newTerminal()
+	f, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return osc52Backend{term: t}, nil
+}
+
+// osc52Selection returns the OSC 52 selection parameter for sel.
+func osc52Selection(sel Selection) string {
+	if sel == PrimarySelection {
+		return "p"
+	}
+	return "c"
+}
+
+// osc52Complete reports whether buf holds a whole OSC 52 reply, terminated
+// by BEL or ST.
+func osc52Complete(buf []byte) bool {
+	i := bytes.Index(buf, []byte("\x1b]52;"))
+	if i < 0 {
+		return false
+	}
+	rest := buf[i:]
+	return bytes.IndexByte(rest, '\a') >= 0 || bytes.Contains(rest, []byte("\x1b\\"))
+}
+
+// parseOSC52 returns the data of an OSC 52 reply, such as
+// "\x1b]52;c;aGVsbG8=\a".
+func parseOSC52(reply []byte) ([]byte, error) {
+	i := bytes.Index(reply, []byte("\x1b]52;"))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: invalid OSC 52 reply", ErrUnavailable)
+	}
+	payload := reply[i+len("\x1b]52;"):]
+	if end := bytes.IndexAny(payload, "\a\x1b"); end >= 0 {
+		payload = payload[:end]
+	}
+	// Skip the selection parameter.
+	if j := bytes.IndexByte(payload, ';'); j >= 0 {
+		payload = payload[j+1:]
+	}
+
+	data, err := base64.StdEncoding.DecodeString(string(payload))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid OSC 52 reply: %v", ErrUnavailable, err)
+	}
+	return data, nil
+}
+
+func (osc52Backend) supports(Selection) bool {
+	return true
+}
+
+// readText asks the terminal for the text in sel. It returns nil if the
+// selection is empty.
+func (b osc52Backend) readText(sel Selection) ([]byte, error) {
+	query := "\x1b]52;" + osc52Selection(sel) + ";?\a"
+	reply, err := b.term.query(query, osc52Timeout, osc52Complete)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseOSC52(reply)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (b osc52Backend) read(sel Selection, t Format) ([]byte, error) {
+	if t != Text {
+		return nil, ErrUnsupported
+	}
+	data, err := b.readText(sel)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, ErrUnavailable
+	}
+	return data, nil
+}
+
+func (b osc52Backend) readAny(sel Selection, formats []Format) (Format, Entry, error) {
+	for _, f := range formats {
+		if f != Text && f != Image {
+			return 0, Entry{}, ErrUnsupported
+		}
+	}
+	for _, f := range formats {
+		if f != Text {
+			continue
+		}
+		data, err := b.read(sel, f)
+		if err != nil {
+			return 0, Entry{}, err
+		}
+		return f, Entry{Target: osc52Target, Data: data}, nil
+	}
+	return 0, Entry{}, ErrUnavailable
+}
+
+// write sends the text to the terminal. The returned channel never fires,
+// since the terminal doesn't report changes.
+func (b osc52Backend) write(sel Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	if t != Text {
+		return nil, ErrUnsupported
+	}
+	seq := "\x1b]52;" + osc52Selection(sel) + ";" + base64.StdEncoding.EncodeToString(buf) + "\a"
+	if err := b.term.write(seq); err != nil {
+		return nil, err
+	}
+	return make(chan struct{}, 1), nil
+}
+
+func (osc52Backend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
+	return nil, fmt.Errorf("%w: terminals don't report clipboard changes", ErrUnsupported)
+}
+
+func (b osc52Backend) snapshot(sel Selection) (*Contents, error) {
+	data, err := b.readText(sel)
+	if err != nil {
+		return nil, err
+	}
+	contents := &Contents{}
+	if data != nil {
+		contents.Entries = append(contents.Entries, Entry{Target: osc52Target, Data: data})
+	}
+	return contents, nil
+}
+
+// restore writes the first text entry of c. Empty contents clear the
+// clipboard.
+func (b osc52Backend) restore(sel Selection, c *Contents) (<-chan struct{}, error) {
+	if len(c.Entries) == 0 {
+		return b.write(sel, Text, nil)
+	}
+	for _, e := range c.Entries {
+		if textTargets[e.Target] {
+			return b.write(sel, Text, e.Data)
+		}
+	}
+	return nil, ErrUnsupported
+}
+
+// flush reports true, the terminal keeps the clipboard.
+func (osc52Backend) flush(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// changeCount fails, the terminal doesn't report changes.
+func (osc52Backend) changeCount(Selection) (uint64, error) {
+	return 0, fmt.Errorf("%w: the terminal doesn't report clipboard changes", ErrUnsupported)
+}
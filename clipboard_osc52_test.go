@@ -0,0 +1,114 @@
+//go:build linux && !android
+
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newOSC52Terminal returns a fake terminal implementing OSC 52, and a
+// function returning the base64 contents of its selections.
+func newOSC52Terminal(t *testing.T) (*fakeTerminal, func(sel string) string) {
+	var mu sync.Mutex
+	selections := make(map[string]string)
+	ft := newFakeTerminal(t, func(osc string) string {
+		parts := strings.SplitN(osc, ";", 3)
+		if len(parts) != 3 || parts[0] != "52" {
+			return ""
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if parts[2] == "?" {
+			return "\x1b]52;" + parts[1] + ";" + selections[parts[1]] + "\x1b\\"
+		}
+		selections[parts[1]] = parts[2]
+		return ""
+	})
+	return ft, func(sel string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return selections[sel]
+	}
+}
+
+func TestOSC52WriteRead(t *testing.T) {
+	ft, selections := newOSC52Terminal(t)
+	b := osc52Backend{term: terminal{path: ft.path}}
+
+	if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable from an empty clipboard, got %v", err)
+	}
+
+	if _, err := b.write(ClipboardSelection, Text, []byte("Hello, terminal!")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if _, err := b.write(PrimarySelection, Text, []byte("Selected")); err != nil {
+		t.Fatalf("write to primary failed: %v", err)
+	}
+
+	data, err := b.read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "Hello, terminal!" {
+		t.Fatalf("Expected %q, got %q", "Hello, terminal!", data)
+	}
+	if got := selections("c"); got != "SGVsbG8sIHRlcm1pbmFsIQ==" {
+		t.Fatalf("Unexpected base64 payload %q", got)
+	}
+
+	f, e, err := b.readAny(PrimarySelection, []Format{Image, Text})
+	if err != nil {
+		t.Fatalf("readAny failed: %v", err)
+	}
+	if f != Text || string(e.Data) != "Selected" {
+		t.Fatalf("Unexpected result: %v %q", f, e.Data)
+	}
+}
+
+func TestOSC52Unsupported(t *testing.T) {
+	ft, _ := newOSC52Terminal(t)
+	b := osc52Backend{term: terminal{path: ft.path}}
+
+	if _, err := b.write(ClipboardSelection, Image, []byte("png")); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported writing an image, got %v", err)
+	}
+	if _, err := b.watch(context.Background(), ClipboardSelection, Text); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported watching, got %v", err)
+	}
+}
+
+func TestOSC52NoReply(t *testing.T) {
+	ft := newFakeTerminal(t, func(string) string { return "" })
+	b := osc52Backend{term: terminal{path: ft.path}}
+
+	if _, err := b.read(ClipboardSelection, Text); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable, got %v", err)
+	}
+}
+
+func TestParseOSC52(t *testing.T) {
+	for _, tc := range []struct {
+		reply string
+		want  string
+	}{
+		{"\x1b]52;c;aGVsbG8=\a", "hello"},
+		{"\x1b]52;p;aGVsbG8=\x1b\\", "hello"},
+		{"garbage\x1b]52;c;\a", ""},
+	} {
+		got, err := parseOSC52([]byte(tc.reply))
+		if err != nil {
+			t.Fatalf("parseOSC52(%q) failed: %v", tc.reply, err)
+		}
+		if string(got) != tc.want {
+			t.Fatalf("parseOSC52(%q) = %q, want %q", tc.reply, got, tc.want)
+		}
+	}
+	if _, err := parseOSC52([]byte("\x1b]52;c;not base64!\a")); err == nil {
+		t.Fatal("Expected an error for invalid base64")
+	}
+}
@@ -5,24 +5,8 @@
 
 package nativeclipboard
 
-import (
-	"context"
-)
+// backends is empty, the platform has no system clipboard we can reach. Only
+// the memory backend is available.
+var backends []backendFactory
 
-func initialize() error {
-	return ErrUnavailable
-}
-
-func read(t Format) ([]byte, error) {
-	return nil, ErrUnavailable
-}
-
-func write(t Format, buf []byte) (<-chan struct{}, error) {
-	return nil, ErrUnavailable
-}
-
-func watch(ctx context.Context, t Format) <-chan []byte {
-	ch := make(chan []byte)
-	close(ch)
-	return ch
-}
+var autoBackends []backendFactory
@@ -27,9 +27,11 @@ func createTestPNG(width, height int, c color.Color) ([]byte, error) {
 }
 
 func TestInit(t *testing.T) {
-	// Init happens automatically, just check for errors
-	if initError != nil {
-		t.Fatalf("Init failed: %v", initError)
+	if err := Init(context.Background()); err != nil {
+		t.Fatalf("Init failed: %v", err)
+	}
+	if d := Backend(); d.Backend == "" {
+		t.Fatalf("No backend after Init: %+v", d.Rejected)
 	}
 }
 
@@ -243,3 +245,48 @@ func TestMultipleImageReads(t *testing.T) {
 	}
 }
 
+
+func TestReadAny(t *testing.T) {
+	testData := []byte("Read any test")
+	if _, err := Text.Write(testData); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Image is preferred but only text is offered
+	f, e, err := ReadAnyEntry(Image, Text)
+	if err != nil {
+		t.Fatalf("ReadAnyEntry failed: %v", err)
+	}
+	if f != Text {
+		t.Fatalf("Expected Text format, got %v", f)
+	}
+	if e.Target == "" {
+		t.Fatal("ReadAnyEntry returned empty target")
+	}
+	if string(e.Data) != string(testData) {
+		t.Fatalf("Expected %q, got %q", testData, e.Data)
+	}
+
+	testImage, err := createTestPNG(4, 4, color.RGBA{255, 255, 0, 255})
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	if _, err := Image.Write(testImage); err != nil {
+		t.Fatalf("Image.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	f, data, err := ReadAny(Text, Image)
+	if err != nil {
+		t.Fatalf("ReadAny failed: %v", err)
+	}
+	if f != Image {
+		t.Fatalf("Expected Image format, got %v", f)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadAny returned invalid PNG data: %v", err)
+	}
+}
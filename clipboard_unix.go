@@ -0,0 +1,52 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"fmt"
+	"os"
+)
+
+// backends lists the backends that can be selected by name.
+var backends = []backendFactory{
+	{"wayland", ignoreOptions(newWaylandBackend)},
+	{"x11", newX11Backend},
+	{"xproto", newXprotoBackend},
+	{"command", ignoreOptions(newCommandBackend)},
+	{"tmux", func(*options) (backend, error) { return newToolBackend(&tmuxBuffers) }},
+	{"terminal", ignoreOptions(newTerminalBackend)},
+	{"kitty", ignoreOptions(newKittyBackend)},
+	{"osc52", ignoreOptions(newOSC52Backend)},
+}
+
+// autoBackends is the automatic detection chain. The native Wayland backend
+// is used in Wayland sessions, unless an X display is configured, then X11,
+// which also covers XWayland, through libX11 or the X11 protocol when libX11
+// isn't installed. libX11 older than 1.7 comes after the X11 protocol, since
+// it exits the process when the connection is lost. If neither works,
+// external clipboard tools are used when installed, and SSH sessions fall
+// back to the terminal clipboard.
+var autoBackends = []backendFactory{
+	{"wayland", func(o *options) (backend, error) {
+		if o.display != "" {
+			return nil, fmt.Errorf("%w: an X display is configured", ErrUnavailable)
+		}
+		if os.Getenv("WAYLAND_DISPLAY") == "" {
+			return nil, fmt.Errorf("%w: $WAYLAND_DISPLAY is not set", ErrUnavailable)
+		}
+		return newWaylandBackend()
+	}},
+	{"x11", newRecentX11Backend},
+	{"xproto", newXprotoBackend},
+	{"x11", newX11Backend},
+	{"command", ignoreOptions(newCommandBackend)},
+	{"terminal", func(*options) (backend, error) {
+		if os.Getenv("SSH_TTY") == "" {
+			return nil, fmt.Errorf("%w: not in an SSH session", ErrUnavailable)
+		}
+		return newTerminalBackend()
+	}},
+}
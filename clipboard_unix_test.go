@@ -0,0 +1,32 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDetectChain(t *testing.T) {
+	t.Setenv(backendEnv, "")
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("DISPLAY", "")
+	t.Setenv("SSH_TTY", "")
+	t.Setenv("PATH", t.TempDir())
+
+	c, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	d := c.Detection()
+	if d.Backend != "memory" {
+		t.Fatalf("Expected the memory backend, got %q", d.Backend)
+	}
+	var rejected []string
+	for _, r := range d.Rejected {
+		rejected = append(rejected, r.Backend)
+	}
+	if want := []string{"wayland", "x11", "xproto", "x11", "command", "terminal"}; !slices.Equal(rejected, want) {
+		t.Fatalf("Expected %v to be rejected, got %v", want, rejected)
+	}
+}
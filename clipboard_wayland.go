@@ -0,0 +1,669 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// waylandBackend implements the clipboard on Wayland, talking to the
+// compositor directly over $WAYLAND_DISPLAY.
+//
+// When the compositor exposes one of the data control protocols
+// (ext-data-control-v1 or its predecessor wlr-data-control-unstable-v1), the
+// selection is accessed through it, which works for background processes
+// without any window.
+//
+// Otherwise the core data device protocol is used. Compositors only hand the
+// selection to, and accept it from, the client with keyboard focus, so every
+// operation maps a tiny transparent surface to obtain focus for as long as it
+// needs it, the same way wl-copy and wl-paste do. Watching the clipboard is
+// not possible this way. The primary selection is reached the same way
+// through the primary selection protocol.
+type waylandBackend struct{}
+
+// newWaylandBackend makes sure the compositor offers everything either the
+// data control or the data device protocol needs.
+func newWaylandBackend() (backend, error) {
+	s, err := newWlSession(ClipboardSelection)
+	if err != nil {
+		return nil, err
+	}
+	s.close()
+	return waylandBackend{}, nil
+}
+
+// mimeTypes returns the MIME types used for a format, in order of
+// preference.
+func mimeTypes(t Format) ([]string, error) {
+	switch t {
+	case Text:
+		return []string{"text/plain;charset=utf-8", "UTF8_STRING", "text/plain"}, nil
+	case Image:
+		return []string{"image/png"}, nil
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// wlOffer is a data offer advertised by the compositor.
+type wlOffer struct {
+	id    uint32
+	mimes []string
+}
+
+func (o *wlOffer) has(mime string) bool {
+	for _, m := range o.mimes {
+		if m == mime {
+			return true
+		}
+	}
+	return false
+}
+
+// wlSelectionProtocol describes a protocol giving access to the selection.
+// The data control protocols mirror the core data device protocol, with
+// different opcodes and without the need for keyboard focus.
+type wlSelectionProtocol struct {
+	// focus reports whether the selection is only available to the client
+	// with keyboard focus.
+	focus bool
+
+	// Events and requests of the device. Devices reporting both selections
+	// announce the one we don't use with other.
+	selection    uint16
+	other        uint16 // 0 if the device has a single selection
+	finished     uint16 // 0 if the device can't be finished
+	setSelection uint16
+
+	// Requests of offers.
+	receive      uint16
+	destroyOffer uint16
+
+	// Events of sources.
+	send      uint16
+	cancelled uint16
+}
+
+// wlDataDevice is wl_data_device_manager and the objects it creates.
+var wlDataDevice = wlSelectionProtocol{
+	focus:        true,
+	selection:    5,
+	setSelection: 1,
+	receive:      1,
+	destroyOffer: 2,
+	send:         1,
+	cancelled:    2,
+}
+
+// wlPrimarySelection is zwp_primary_selection_device_manager_v1 and the
+// objects it creates.
+var wlPrimarySelection = wlSelectionProtocol{
+	focus:        true,
+	selection:    1,
+	setSelection: 0,
+	receive:      0,
+	destroyOffer: 1,
+	send:         0,
+	cancelled:    1,
+}
+
+// wlDataControl is ext_data_control_manager_v1 and
+// zwlr_data_control_manager_v1, which share their opcodes, and the objects
+// they create.
+var wlDataControl = wlSelectionProtocol{
+	selection:    1,
+	other:        3,
+	finished:     2,
+	setSelection: 0,
+	receive:      0,
+	destroyOffer: 1,
+	send:         0,
+	cancelled:    1,
+}
+
+// wlDataControlPrimary is wlDataControl used for the primary selection,
+// which data control devices report along with the clipboard.
+var wlDataControlPrimary = wlSelectionProtocol{
+	selection:    3,
+	other:        1,
+	finished:     2,
+	setSelection: 2,
+	receive:      0,
+	destroyOffer: 1,
+	send:         0,
+	cancelled:    1,
+}
+
+// wlDataControlManagers lists the data control managers we support, in order
+// of preference, with the version that added the primary selection.
+var wlDataControlManagers = []struct {
+	iface   string
+	primary uint32
+}{
+	{"ext_data_control_manager_v1", 1},
+	{"zwlr_data_control_manager_v1", 2},
+}
+
+// wlSession is a connection to the compositor with the globals and objects
+// needed to access the selection.
+type wlSession struct {
+	c     *wlConn
+	proto *wlSelectionProtocol
+
+	compositor uint32
+	shm        uint32
+	wmBase     uint32
+	seat       uint32
+	manager    uint32
+	device     uint32
+	keyboard   uint32
+
+	// serial is the serial of the last keyboard enter event, needed to set
+	// the selection.
+	serial  uint32
+	focused bool
+
+	// offer is the current selection, or nil if the selection is empty.
+	// selections counts the selection events received.
+	offer      *wlOffer
+	offers     map[uint32]*wlOffer
+	selections int
+	// finished is set once the compositor invalidated the data control
+	// device.
+	finished bool
+
+	surface    uint32
+	xdgSurface uint32
+	toplevel   uint32
+	buffer     uint32
+}
+
+// newWlSession connects to the compositor and binds the globals used to
+// access sel.
+func newWlSession(sel Selection) (*wlSession, error) {
+	c, err := wlConnect()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &wlSession{c: c, offers: make(map[uint32]*wlOffer)}
+	if err := s.init(sel); err != nil {
+		c.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *wlSession) init(sel Selection) error {
+	deadline := wlDeadline()
+	c := s.c
+
+	registry, globals, err := c.registry(deadline)
+	if err != nil {
+		return err
+	}
+	if _, ok := globals["wl_seat"]; !ok {
+		return fmt.Errorf("%w: compositor doesn't support wl_seat", ErrUnavailable)
+	}
+	s.seat, _ = c.bind(registry, "wl_seat", globals["wl_seat"], 5, nil)
+
+	for _, m := range wlDataControlManagers {
+		g, ok := globals[m.iface]
+		if !ok {
+			continue
+		}
+		if sel == PrimarySelection {
+			if g.version < m.primary {
+				continue
+			}
+			s.proto = &wlDataControlPrimary
+		} else {
+			s.proto = &wlDataControl
+		}
+		s.manager, _ = c.bind(registry, m.iface, g, m.primary, nil)
+		break
+	}
+
+	if s.proto == nil {
+		manager, proto, version := "wl_data_device_manager", &wlDataDevice, uint32(3)
+		if sel == PrimarySelection {
+			manager, proto, version = "zwp_primary_selection_device_manager_v1", &wlPrimarySelection, 1
+			if _, ok := globals[manager]; !ok {
+				return fmt.Errorf("%w: compositor doesn't support the primary selection", ErrUnsupported)
+			}
+		}
+		for _, iface := range []string{"wl_compositor", "wl_shm", "xdg_wm_base", manager} {
+			if _, ok := globals[iface]; !ok {
+				return fmt.Errorf("%w: compositor doesn't support %s", ErrUnavailable, iface)
+			}
+		}
+
+		s.proto = proto
+		s.compositor, _ = c.bind(registry, "wl_compositor", globals["wl_compositor"], 4, nil)
+		s.shm, _ = c.bind(registry, "wl_shm", globals["wl_shm"], 1, nil)
+		s.wmBase, _ = c.bind(registry, "xdg_wm_base", globals["xdg_wm_base"], 1, func(opcode uint16, d *wlDecoder) {
+			if opcode == 0 { // ping
+				c.send(s.wmBase, 3, d.uint()) // xdg_wm_base.pong
+			}
+		})
+		s.manager, _ = c.bind(registry, manager, globals[manager], version, nil)
+
+		s.keyboard = c.newID(s.handleKeyboard)
+		c.send(s.seat, 1, s.keyboard) // wl_seat.get_keyboard
+	}
+
+	// All managers create devices with the same request, and the compositor
+	// sends the current selection right away.
+	s.device = c.newID(s.handleDevice)
+	c.send(s.manager, 1, s.device, s.seat) // get_data_device
+
+	return c.roundtrip(deadline)
+}
+
+func (s *wlSession) close() {
+	s.c.close()
+}
+
+// handleDevice handles data device events.
+func (s *wlSession) handleDevice(opcode uint16, d *wlDecoder) {
+	switch {
+	case opcode == 0: // data_offer
+		o := &wlOffer{id: d.uint()}
+		s.offers[o.id] = o
+		s.c.setHandler(o.id, func(opcode uint16, d *wlDecoder) {
+			if opcode == 0 { // offer
+				o.mimes = append(o.mimes, d.string())
+			}
+		})
+	case opcode == s.proto.selection:
+		id := d.uint()
+		if s.offer != nil && s.offer.id != id {
+			s.c.send(s.offer.id, s.proto.destroyOffer)
+			delete(s.offers, s.offer.id)
+		}
+		s.offer = s.offers[id]
+		s.selections++
+	case opcode == s.proto.other && s.proto.other != 0:
+		// Offers for the selection we don't use aren't needed.
+		if o, ok := s.offers[d.uint()]; ok && o != s.offer {
+			s.c.send(o.id, s.proto.destroyOffer)
+			delete(s.offers, o.id)
+		}
+	case opcode == s.proto.finished && s.proto.finished != 0:
+		s.finished = true
+	}
+}
+
+// handleKeyboard handles wl_keyboard events.
+func (s *wlSession) handleKeyboard(opcode uint16, d *wlDecoder) {
+	switch opcode {
+	case 0: // keymap
+		d.uint()
+		if fd := d.fd(); fd >= 0 {
+			syscall.Close(fd)
+		}
+	case 1: // enter
+		s.serial = d.uint()
+		s.focused = true
+	case 2: // leave
+		s.focused = false
+	}
+}
+
+// focus maps a 1x1 transparent toplevel surface and waits for the
+// compositor to give it keyboard focus. The current selection is known once
+// focus returns.
+func (s *wlSession) focus(deadline time.Time) error {
+	c := s.c
+
+	configured := false
+	s.surface = c.newID(nil)
+	c.send(s.compositor, 0, s.surface) // wl_compositor.create_surface
+	s.xdgSurface = c.newID(func(opcode uint16, d *wlDecoder) {
+		if opcode == 0 { // configure
+			c.send(s.xdgSurface, 4, d.uint()) // xdg_surface.ack_configure
+			configured = true
+		}
+	})
+	c.send(s.wmBase, 2, s.xdgSurface, s.surface) // xdg_wm_base.get_xdg_surface
+	s.toplevel = c.newID(nil)
+	c.send(s.xdgSurface, 1, s.toplevel)      // xdg_surface.get_toplevel
+	c.send(s.toplevel, 2, "nativeclipboard") // xdg_toplevel.set_title
+	c.send(s.surface, 6)                     // wl_surface.commit
+
+	if err := c.dispatchUntil(deadline, func() bool { return configured }); err != nil {
+		return fmt.Errorf("%w: surface wasn't configured: %v", ErrUnavailable, err)
+	}
+
+	buffer, err := s.createBuffer()
+	if err != nil {
+		return err
+	}
+	s.buffer = buffer
+	c.send(s.surface, 1, s.buffer, int32(0), int32(0)) // wl_surface.attach
+	c.send(s.surface, 6)                               // wl_surface.commit
+
+	if err := c.dispatchUntil(deadline, func() bool { return s.focused }); err != nil {
+		return fmt.Errorf("%w: no keyboard focus: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// unfocus destroys the surface created by focus.
+func (s *wlSession) unfocus() {
+	c := s.c
+	if s.toplevel != 0 {
+		c.send(s.toplevel, 0) // xdg_toplevel.destroy
+	}
+	if s.xdgSurface != 0 {
+		c.send(s.xdgSurface, 0) // xdg_surface.destroy
+	}
+	if s.surface != 0 {
+		c.send(s.surface, 0) // wl_surface.destroy
+	}
+	if s.buffer != 0 {
+		c.send(s.buffer, 0) // wl_buffer.destroy
+	}
+	s.toplevel, s.xdgSurface, s.surface, s.buffer = 0, 0, 0, 0
+}
+
+// createBuffer creates a 1x1 fully transparent ARGB8888 shared memory
+// buffer.
+func (s *wlSession) createBuffer() (uint32, error) {
+	f, err := os.CreateTemp(os.Getenv("XDG_RUNTIME_DIR"), "nativeclipboard-*")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if err := f.Truncate(4); err != nil {
+		return 0, err
+	}
+
+	c := s.c
+	pool := c.newID(nil)
+	c.send(s.shm, 0, pool, wlFD(f.Fd()), int32(4)) // wl_shm.create_pool
+	buffer := c.newID(nil)
+	c.send(pool, 0, buffer, int32(0), int32(1), int32(1), int32(4), uint32(0)) // wl_shm_pool.create_buffer
+	c.send(pool, 1)                                                            // wl_shm_pool.destroy
+	return buffer, nil
+}
+
+// receive asks the owner of the selection to send it as mime and reads the
+// data.
+func (s *wlSession) receive(offer *wlOffer, mime string) ([]byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	err = s.c.send(offer.id, s.proto.receive, mime, wlFD(w.Fd()))
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	r.SetReadDeadline(wlDeadline())
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return data, nil
+}
+
+// readOffer reads the first of mimes that offer provides. It returns nil
+// data if offer provides none of them.
+func (s *wlSession) readOffer(offer *wlOffer, mimes []string) (string, []byte, error) {
+	if offer == nil {
+		return "", nil, nil
+	}
+	for _, mime := range mimes {
+		if offer.has(mime) {
+			data, err := s.receive(offer, mime)
+			return mime, data, err
+		}
+	}
+	return "", nil, nil
+}
+
+// selection opens a session and returns it along with the current offer for
+// sel, or nil if the selection is empty.
+func (waylandBackend) selection(sel Selection) (*wlSession, *wlOffer, error) {
+	s, err := newWlSession(sel)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.proto.focus {
+		if err := s.focus(wlDeadline()); err != nil {
+			s.close()
+			return nil, nil, err
+		}
+		s.unfocus()
+	}
+	return s, s.offer, nil
+}
+
+// supports reports true, compositors without the primary selection are
+// reported when it's used.
+func (waylandBackend) supports(Selection) bool {
+	return true
+}
+
+func (b waylandBackend) read(sel Selection, t Format) ([]byte, error) {
+	_, e, err := b.readAny(sel, []Format{t})
+	if err != nil {
+		return nil, err
+	}
+	return e.Data, nil
+}
+
+func (b waylandBackend) readAny(sel Selection, formats []Format) (Format, Entry, error) {
+	mimes := make([][]string, len(formats))
+	for i, f := range formats {
+		m, err := mimeTypes(f)
+		if err != nil {
+			return 0, Entry{}, err
+		}
+		mimes[i] = m
+	}
+
+	s, offer, err := b.selection(sel)
+	if err != nil {
+		return 0, Entry{}, err
+	}
+	defer s.close()
+	if offer == nil {
+		return 0, Entry{}, ErrUnavailable
+	}
+
+	for i, f := range formats {
+		mime, data, err := s.readOffer(offer, mimes[i])
+		if err != nil {
+			return 0, Entry{}, err
+		}
+		if data != nil {
+			return f, Entry{Target: mime, Data: data}, nil
+		}
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
+func (b waylandBackend) write(sel Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	mimes, err := mimeTypes(t)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, len(mimes))
+	for i, mime := range mimes {
+		entries[i] = Entry{Target: mime, Data: buf}
+	}
+	return b.own(sel, entries)
+}
+
+// watch listens for selection events from a data control device. Without
+// data control the selection is only known while we have focus, so watching
+// is unsupported.
+func (waylandBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
+	mimes, err := mimeTypes(t)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := newWlSession(sel)
+	if err != nil {
+		return nil, err
+	}
+	if s.proto.focus {
+		s.close()
+		return nil, fmt.Errorf("%w: watching a selection on Wayland requires a compositor with ext-data-control or wlr-data-control", ErrUnsupported)
+	}
+
+	_, last, _ := s.readOffer(s.offer, mimes)
+	recv := make(chan []byte, 1)
+
+	go func() {
+		defer close(recv)
+		defer s.close()
+
+		// Closing the connection interrupts the blocking dispatch below.
+		stop := context.AfterFunc(ctx, func() { s.c.conn.Close() })
+		defer stop()
+
+		for {
+			n := s.selections
+			err := s.c.dispatchUntil(time.Time{}, func() bool { return s.selections != n || s.finished })
+			if err != nil || s.finished {
+				return
+			}
+
+			_, data, _ := s.readOffer(s.offer, mimes)
+			if data == nil || bytes.Equal(last, data) {
+				continue
+			}
+			last = data
+
+			select {
+			case recv <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return recv, nil
+}
+
+func (b waylandBackend) snapshot(sel Selection) (*Contents, error) {
+	s, offer, err := b.selection(sel)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+
+	contents := &Contents{}
+	if offer == nil {
+		return contents, nil
+	}
+	for _, mime := range offer.mimes {
+		data, err := s.receive(offer, mime)
+		if err != nil {
+			continue
+		}
+		contents.Entries = append(contents.Entries, Entry{Target: mime, Data: data})
+	}
+	return contents, nil
+}
+
+func (b waylandBackend) restore(sel Selection, c *Contents) (<-chan struct{}, error) {
+	return b.own(sel, c.Entries)
+}
+
+// flush reports false, the compositor drops the selection once we exit. A
+// clipboard manager may still take a copy on its own.
+func (waylandBackend) flush(ctx context.Context) (bool, error) {
+	return false, nil
+}
+
+// own sets the selection to a data source offering the given entries and
+// serves them until another client sets the selection.
+func (waylandBackend) own(sel Selection, entries []Entry) (<-chan struct{}, error) {
+	errCh := make(chan error, 1)
+	done := make(chan struct{}, 1)
+
+	go func() {
+		s, err := newWlSession(sel)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer s.close()
+
+		if s.proto.focus {
+			if err := s.focus(wlDeadline()); err != nil {
+				errCh <- err
+				return
+			}
+		}
+
+		c := s.c
+		data := make(map[string][]byte, len(entries))
+		cancelled := false
+		source := c.newID(func(opcode uint16, d *wlDecoder) {
+			switch opcode {
+			case s.proto.send:
+				mime, fd := d.string(), d.fd()
+				if fd < 0 {
+					return
+				}
+				f := os.NewFile(uintptr(fd), "pipe")
+				go func() {
+					defer f.Close()
+					f.Write(data[mime])
+				}()
+			case s.proto.cancelled:
+				cancelled = true
+			}
+		})
+		c.send(s.manager, 0, source) // create_data_source
+		for _, e := range entries {
+			if _, ok := data[e.Target]; !ok {
+				c.send(source, 0, e.Target) // offer
+			}
+			data[e.Target] = e.Data
+		}
+		if s.proto.focus {
+			c.send(s.device, s.proto.setSelection, source, s.serial)
+			s.unfocus()
+		} else {
+			c.send(s.device, s.proto.setSelection, source)
+		}
+
+		if err := c.roundtrip(wlDeadline()); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- nil
+
+		c.dispatchUntil(time.Time{}, func() bool { return cancelled || s.finished })
+		c.send(source, 1) // destroy
+		close(done)
+	}()
+
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	return done, nil
+}
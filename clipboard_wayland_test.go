@@ -0,0 +1,235 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaylandWriteRead(t *testing.T) {
+	newFakeCompositor(t)
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	testData := []byte("Hello, Wayland!")
+	if _, err := b.write(ClipboardSelection, Text, testData); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	data, err := b.read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != string(testData) {
+		t.Fatalf("Expected %q, got %q", testData, data)
+	}
+
+	if _, err := b.read(ClipboardSelection, Image); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable reading an image, got %v", err)
+	}
+}
+
+func TestWaylandReadOtherClient(t *testing.T) {
+	srv := newFakeCompositor(t)
+	srv.setSelection([]string{"text/plain", "image/png"}, map[string][]byte{
+		"text/plain": []byte("From another app"),
+		"image/png":  []byte("not really a png"),
+	})
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	f, e, err := b.readAny(ClipboardSelection, []Format{Text, Image})
+	if err != nil {
+		t.Fatalf("readAny failed: %v", err)
+	}
+	if f != Text || e.Target != "text/plain" || string(e.Data) != "From another app" {
+		t.Fatalf("Unexpected result: %v %q %q", f, e.Target, e.Data)
+	}
+
+	c, err := b.snapshot(ClipboardSelection)
+	if err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+	if len(c.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", c.Entries)
+	}
+}
+
+func TestWaylandWriteChanged(t *testing.T) {
+	srv := newFakeCompositor(t)
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	changed, err := b.write(ClipboardSelection, Text, []byte("First"))
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got := srv.selectionMimes(); len(got) == 0 || got[0] != "text/plain;charset=utf-8" {
+		t.Fatalf("Unexpected offered MIME types: %v", got)
+	}
+
+	srv.setSelection([]string{"text/plain"}, map[string][]byte{"text/plain": []byte("Second")})
+
+	select {
+	case <-changed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for change notification")
+	}
+}
+
+func TestWaylandWatchUnsupported(t *testing.T) {
+	newFakeCompositor(t)
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	if _, err := b.watch(context.Background(), ClipboardSelection, Text); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported without data control, got %v", err)
+	}
+}
+
+func TestWaylandDataControl(t *testing.T) {
+	for _, m := range wlDataControlManagers {
+		manager := m.iface
+		t.Run(manager, func(t *testing.T) {
+			// Without a compositor, shell or keyboard, only data control can
+			// reach the selection.
+			srv := newFakeCompositor(t, fakeGlobal{"wl_seat", 5}, fakeGlobal{manager, m.primary})
+
+			b, err := newWaylandBackend()
+			if err != nil {
+				t.Fatalf("newWaylandBackend failed: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ch, err := b.watch(ctx, ClipboardSelection, Text)
+			if err != nil {
+				t.Fatalf("watch failed: %v", err)
+			}
+
+			changed, err := b.write(ClipboardSelection, Text, []byte("Background"))
+			if err != nil {
+				t.Fatalf("write failed: %v", err)
+			}
+			select {
+			case data := <-ch:
+				if string(data) != "Background" {
+					t.Fatalf("Expected %q, got %q", "Background", data)
+				}
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for clipboard change")
+			}
+
+			data, err := b.read(ClipboardSelection, Text)
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+			if string(data) != "Background" {
+				t.Fatalf("Expected %q, got %q", "Background", data)
+			}
+
+			srv.setSelection([]string{"text/plain"}, map[string][]byte{"text/plain": []byte("Other")})
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for change notification")
+			}
+			select {
+			case data := <-ch:
+				if string(data) != "Other" {
+					t.Fatalf("Expected %q, got %q", "Other", data)
+				}
+			case <-ctx.Done():
+				t.Fatal("Timeout waiting for clipboard change")
+			}
+
+			// The primary selection is separate from the clipboard.
+			if _, err := b.write(PrimarySelection, Text, []byte("Selected")); err != nil {
+				t.Fatalf("write to primary failed: %v", err)
+			}
+			if data, err := b.read(PrimarySelection, Text); err != nil || string(data) != "Selected" {
+				t.Fatalf("Expected %q from primary, got %q (%v)", "Selected", data, err)
+			}
+			if data, err := b.read(ClipboardSelection, Text); err != nil || string(data) != "Other" {
+				t.Fatalf("Expected %q from clipboard, got %q (%v)", "Other", data, err)
+			}
+
+			cancel()
+			for range ch {
+			}
+		})
+	}
+}
+
+func TestWaylandPrimary(t *testing.T) {
+	globals := append(fakeDataDeviceGlobals, fakeGlobal{"zwp_primary_selection_device_manager_v1", 1})
+	srv := newFakeCompositor(t, globals...)
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	if _, err := b.write(PrimarySelection, Text, []byte("Selected")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if got := srv.selectionMimesOf(PrimarySelection); len(got) == 0 {
+		t.Fatal("Primary selection wasn't set")
+	}
+	if got := srv.selectionMimes(); got != nil {
+		t.Fatalf("Clipboard shouldn't be set, got %v", got)
+	}
+
+	data, err := b.read(PrimarySelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "Selected" {
+		t.Fatalf("Expected %q, got %q", "Selected", data)
+	}
+
+	srv.setSelectionOf(PrimarySelection, []string{"text/plain"}, map[string][]byte{"text/plain": []byte("Other")})
+	data, err = b.read(PrimarySelection, Text)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(data) != "Other" {
+		t.Fatalf("Expected %q, got %q", "Other", data)
+	}
+}
+
+func TestWaylandPrimaryUnsupported(t *testing.T) {
+	newFakeCompositor(t)
+
+	b, err := newWaylandBackend()
+	if err != nil {
+		t.Fatalf("newWaylandBackend failed: %v", err)
+	}
+
+	if _, err := b.read(PrimarySelection, Text); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestWaylandUnavailable(t *testing.T) {
+	t.Setenv("WAYLAND_DISPLAY", "/nonexistent/wayland-socket")
+	if _, err := newWaylandBackend(); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable, got %v", err)
+	}
+}
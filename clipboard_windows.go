@@ -81,26 +81,39 @@ var (
 	user32   = syscall.NewLazyDLL("user32.dll")
 	kernel32 = syscall.NewLazyDLL("kernel32.dll")
 
-	openClipboard                  = user32.NewProc("OpenClipboard")
-	closeClipboard                 = user32.NewProc("CloseClipboard")
-	emptyClipboard                 = user32.NewProc("EmptyClipboard")
-	getClipboardData               = user32.NewProc("GetClipboardData")
-	setClipboardData               = user32.NewProc("SetClipboardData")
-	isClipboardFormatAvailable     = user32.NewProc("IsClipboardFormatAvailable")
-	getClipboardSequenceNumber     = user32.NewProc("GetClipboardSequenceNumber")
-	
+	openClipboard              = user32.NewProc("OpenClipboard")
+	closeClipboard             = user32.NewProc("CloseClipboard")
+	emptyClipboard             = user32.NewProc("EmptyClipboard")
+	getClipboardData           = user32.NewProc("GetClipboardData")
+	setClipboardData           = user32.NewProc("SetClipboardData")
+	isClipboardFormatAvailable = user32.NewProc("IsClipboardFormatAvailable")
+	getClipboardSequenceNumber = user32.NewProc("GetClipboardSequenceNumber")
+	enumClipboardFormats       = user32.NewProc("EnumClipboardFormats")
+	getClipboardFormatName     = user32.NewProc("GetClipboardFormatNameW")
+	registerClipboardFormat    = user32.NewProc("RegisterClipboardFormatW")
+
 	gLock   = kernel32.NewProc("GlobalLock")
 	gUnlock = kernel32.NewProc("GlobalUnlock")
 	gAlloc  = kernel32.NewProc("GlobalAlloc")
 	gFree   = kernel32.NewProc("GlobalFree")
+	gSize   = kernel32.NewProc("GlobalSize")
 	memMove = kernel32.NewProc("RtlMoveMemory")
 )
 
-func initialize() error {
-	return nil
+// windowsBackend implements the clipboard on top of the Win32 clipboard API.
+type windowsBackend struct{}
+
+// backends lists the backends that can be selected by name.
+var backends = []backendFactory{{"windows", ignoreOptions(newWindowsBackend)}}
+
+// autoBackends is the automatic detection chain.
+var autoBackends = backends
+
+func newWindowsBackend() (backend, error) {
+	return windowsBackend{}, nil
 }
 
-func read(t Format) ([]byte, error) {
+func (windowsBackend) read(_ Selection, t Format) ([]byte, error) {
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
 
@@ -139,6 +152,55 @@ func read(t Format) ([]byte, error) {
 	}
 }
 
+func (windowsBackend) readAny(_ Selection, formats []Format) (Format, Entry, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Open clipboard
+	for {
+		r, _, _ := openClipboard.Call(0)
+		if r != 0 {
+			break
+		}
+	}
+	defer closeClipboard.Call()
+
+	for _, f := range formats {
+		var candidates []uintptr
+		switch f {
+		case Text:
+			candidates = []uintptr{cfUnicodeText}
+		case Image:
+			candidates = []uintptr{cfDIBV5, cfDIB}
+		default:
+			return 0, Entry{}, ErrUnsupported
+		}
+
+		for _, format := range candidates {
+			if r, _, _ := isClipboardFormatAvailable.Call(format); r == 0 {
+				continue
+			}
+
+			var data []byte
+			var err error
+			switch format {
+			case cfUnicodeText:
+				data, err = readText()
+			case cfDIBV5:
+				data, err = readImage()
+			case cfDIB:
+				data, err = readImageDIB()
+			}
+			if err != nil {
+				continue
+			}
+			return f, Entry{Target: standardFormats[format], Data: data}, nil
+		}
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
 func readText() ([]byte, error) {
 	hMem, _, _ := getClipboardData.Call(cfUnicodeText)
 	if hMem == 0 {
@@ -257,7 +319,7 @@ func bmpToPNG(bmpBuf *bytes.Buffer) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func write(t Format, buf []byte) (<-chan struct{}, error) {
+func (windowsBackend) write(_ Selection, t Format, buf []byte) (<-chan struct{}, error) {
 	errCh := make(chan error, 1)
 	changed := make(chan struct{}, 1)
 
@@ -322,6 +384,8 @@ func writeText(buf []byte) error {
 		return nil
 	}
 
+	// CF_UNICODETEXT is NUL terminated, so text with an embedded NUL would
+	// be cut short. Fail instead, unless TextOptions.StripNUL removed them.
 	s, err := syscall.UTF16FromString(string(buf))
 	if err != nil {
 		return fmt.Errorf("failed to convert string: %w", err)
@@ -421,13 +485,14 @@ func writeImage(buf []byte) error {
 	return nil
 }
 
-func watch(ctx context.Context, t Format) <-chan []byte {
+func (w windowsBackend) watch(ctx context.Context, sel Selection, t Format) (<-chan []byte, error) {
 	recv := make(chan []byte, 1)
 	ready := make(chan struct{})
 
 	go func() {
 		ticker := time.NewTicker(time.Second)
 		defer ticker.Stop()
+		defer close(recv)
 
 		cnt, _, _ := getClipboardSequenceNumber.Call()
 		ready <- struct{}{}
@@ -435,14 +500,17 @@ func watch(ctx context.Context, t Format) <-chan []byte {
 		for {
 			select {
 			case <-ctx.Done():
-				close(recv)
 				return
 			case <-ticker.C:
 				cur, _, _ := getClipboardSequenceNumber.Call()
 				if cnt != cur {
-					b, _ := read(t)
+					b, _ := w.read(sel, t)
 					if b != nil {
-						recv <- b
+						select {
+						case recv <- b:
+						case <-ctx.Done():
+							return
+						}
 					}
 					cnt = cur
 				}
@@ -451,5 +519,213 @@ func watch(ctx context.Context, t Format) <-chan []byte {
 	}()
 
 	<-ready
-	return recv
+	return recv, nil
+}
+
+// standardFormats maps the predefined clipboard formats that hold their data
+// in global memory to their names. Formats that hold GDI handles, such as
+// CF_BITMAP or CF_ENHMETAFILE, cannot be copied as bytes and are left out.
+var standardFormats = map[uintptr]string{
+	1:             "CF_TEXT",
+	4:             "CF_SYLK",
+	5:             "CF_DIF",
+	6:             "CF_TIFF",
+	7:             "CF_OEMTEXT",
+	cfDIB:         "CF_DIB",
+	10:            "CF_PENDATA",
+	11:            "CF_RIFF",
+	12:            "CF_WAVE",
+	cfUnicodeText: "CF_UNICODETEXT",
+	15:            "CF_HDROP",
+	16:            "CF_LOCALE",
+	cfDIBV5:       "CF_DIBV5",
+	0x81:          "CF_DSPTEXT",
+}
+
+// formatName returns the name of a clipboard format, or an empty string if
+// the format cannot be captured.
+func formatName(format uintptr) string {
+	if name, ok := standardFormats[format]; ok {
+		return name
+	}
+	if format < 0xC000 {
+		return ""
+	}
+
+	var buf [256]uint16
+	n, _, _ := getClipboardFormatName.Call(format, uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if n == 0 {
+		return ""
+	}
+	return string(utf16.Decode(buf[:n]))
+}
+
+// formatID returns the clipboard format identified by name, registering it
+// if needed.
+func formatID(name string) (uintptr, error) {
+	for format, n := range standardFormats {
+		if n == name {
+			return format, nil
+		}
+	}
+
+	s, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, fmt.Errorf("invalid format name %q: %w", name, err)
+	}
+	format, _, _ := registerClipboardFormat.Call(uintptr(unsafe.Pointer(s)))
+	if format == 0 {
+		return 0, fmt.Errorf("failed to register clipboard format %q", name)
+	}
+	return format, nil
+}
+
+func (windowsBackend) snapshot(Selection) (*Contents, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Open clipboard
+	for {
+		r, _, _ := openClipboard.Call(0)
+		if r != 0 {
+			break
+		}
+	}
+	defer closeClipboard.Call()
+
+	contents := &Contents{}
+	var format uintptr
+	for {
+		format, _, _ = enumClipboardFormats.Call(format)
+		if format == 0 {
+			break
+		}
+
+		name := formatName(format)
+		if name == "" {
+			continue
+		}
+
+		hMem, _, _ := getClipboardData.Call(format)
+		if hMem == 0 {
+			continue
+		}
+		size, _, _ := gSize.Call(hMem)
+		p, _, _ := gLock.Call(hMem)
+		if p == 0 {
+			continue
+		}
+		data := make([]byte, size)
+		if size > 0 {
+			memMove.Call(uintptr(unsafe.Pointer(&data[0])), p, size)
+		}
+		gUnlock.Call(hMem)
+
+		contents.Entries = append(contents.Entries, Entry{Target: name, Data: data})
+	}
+
+	return contents, nil
+}
+
+func (windowsBackend) restore(_ Selection, c *Contents) (<-chan struct{}, error) {
+	errCh := make(chan error, 1)
+	changed := make(chan struct{}, 1)
+
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		// Open clipboard
+		for {
+			r, _, _ := openClipboard.Call(0)
+			if r != 0 {
+				break
+			}
+		}
+
+		err := restoreEntries(c.Entries)
+		closeClipboard.Call()
+		if err != nil {
+			errCh <- err
+			return
+		}
+
+		cnt, _, _ := getClipboardSequenceNumber.Call()
+		errCh <- nil
+
+		// Monitor for changes
+		for {
+			time.Sleep(time.Second)
+			cur, _, _ := getClipboardSequenceNumber.Call()
+			if cur != cnt {
+				changed <- struct{}{}
+				close(changed)
+				return
+			}
+		}
+	}()
+
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func restoreEntries(entries []Entry) error {
+	r, _, _ := emptyClipboard.Call()
+	if r == 0 {
+		return fmt.Errorf("failed to clear clipboard")
+	}
+
+	for _, e := range entries {
+		format, err := formatID(e.Target)
+		if err != nil {
+			return err
+		}
+
+		hMem, _, _ := gAlloc.Call(gmemMoveable, uintptr(len(e.Data)))
+		if hMem == 0 {
+			return fmt.Errorf("failed to alloc global memory")
+		}
+
+		if len(e.Data) > 0 {
+			p, _, _ := gLock.Call(hMem)
+			if p == 0 {
+				gFree.Call(hMem)
+				return fmt.Errorf("failed to lock global memory")
+			}
+			memMove.Call(p, uintptr(unsafe.Pointer(&e.Data[0])), uintptr(len(e.Data)))
+			gUnlock.Call(hMem)
+		}
+
+		v, _, _ := setClipboardData.Call(format, hMem)
+		if v == 0 {
+			gFree.Call(hMem)
+			return fmt.Errorf("failed to set clipboard data for %s", e.Target)
+		}
+	}
+
+	return nil
+}
+
+// flush reports success right away, the system owns the clipboard data once
+// it has been set.
+func (windowsBackend) flush(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// changeCount returns the clipboard sequence number.
+func (windowsBackend) changeCount(Selection) (uint64, error) {
+	cnt, _, _ := getClipboardSequenceNumber.Call()
+	if cnt == 0 {
+		// The window station has no access to the clipboard.
+		return 0, ErrUnavailable
+	}
+	return uint64(cnt), nil
+}
+
+// supports reports whether sel is the clipboard, Windows has no primary
+// selection.
+func (windowsBackend) supports(sel Selection) bool {
+	return sel == ClipboardSelection
 }
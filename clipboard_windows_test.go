@@ -0,0 +1,28 @@
+package nativeclipboard
+
+import "testing"
+
+func TestWriteTextNUL(t *testing.T) {
+	c, err := New(WithBackend("windows"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("a\x00b")); err == nil {
+		t.Fatal("Expected writing text with a NUL to fail")
+	}
+
+	c, err = New(WithBackend("windows"), WithTextOptions(TextOptions{StripNUL: true}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("a\x00b")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := c.Read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "ab" {
+		t.Fatalf("Expected %q, got %q", "ab", data)
+	}
+}
@@ -6,10 +6,9 @@
 package nativeclipboard
 
 import (
-	"bytes"
-	"context"
+	"encoding/binary"
 	"fmt"
-	"runtime"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -22,19 +21,25 @@ type (
 	Window  uintptr
 	Atom    uintptr
 	Time    uintptr
-	Bool    int
+	Bool    int32
 )
 
 // X11 constants
 const (
-	None         = 0
-	CurrentTime  = 0
-	AnyPropertyType = 0
-	PropModeReplace = 0
-	Success         = 0
-	SelectionNotify = 31
-	SelectionClear  = 29
+	None             = 0
+	CurrentTime      = 0
+	AnyPropertyType  = 0
+	PropModeReplace  = 0
+	PropModeAppend   = 2
+	Success          = 0
+	PropertyNotify   = 28
+	SelectionClear   = 29
 	SelectionRequest = 30
+	SelectionNotify  = 31
+	PropertyNewValue = 0
+	PropertyDelete   = 1
+
+	PropertyChangeMask = 1 << 22
 )
 
 // XEvent is a union in C, we need the largest variant
@@ -43,38 +48,71 @@ type XEvent struct {
 	pad [23]uintptr // Ensure it's large enough for all event types
 }
 
+// XSelectionEvent, XSelectionRequestEvent and XSelectionClearEvent mirror the
+// C structs. The C int type and the Bool typedef are 32 bits wide, and the
+// compiler pads them before the pointer-sized fields on its own.
 type XSelectionEvent struct {
-	typ       int32
-	_         [3]byte // padding
-	serial    uintptr
+	typ        int32
+	serial     uintptr
 	send_event Bool
-	display   Display
-	requestor Window
-	selection Atom
-	target    Atom
-	property  Atom
-	time      Time
+	display    Display
+	requestor  Window
+	selection  Atom
+	target     Atom
+	property   Atom
+	time       Time
 }
 
 type XSelectionRequestEvent struct {
-	typ       int32
-	_         [3]byte
-	serial    uintptr
+	typ        int32
+	serial     uintptr
+	send_event Bool
+	display    Display
+	owner      Window
+	requestor  Window
+	selection  Atom
+	target     Atom
+	property   Atom
+	time       Time
+}
+
+type XSelectionClearEvent struct {
+	typ        int32
+	serial     uintptr
 	send_event Bool
-	display   Display
-	owner     Window
-	requestor Window
-	selection Atom
-	target    Atom
-	property  Atom
-	time      Time
+	display    Display
+	window     Window
+	selection  Atom
+	time       Time
+}
+
+type XPropertyEvent struct {
+	typ        int32
+	serial     uintptr
+	send_event Bool
+	display    Display
+	window     Window
+	atom       Atom
+	time       Time
+	state      int32
+}
+
+// XErrorEvent mirrors the C struct passed to error handlers.
+type XErrorEvent struct {
+	typ          int32
+	display      Display
+	resourceid   uintptr
+	serial       uintptr
+	error_code   uint8
+	request_code uint8
+	minor_code   uint8
 }
 
 // X11 function pointers
 var (
 	libX11 uintptr
 
-	xOpenDisplay        func(display_name uintptr) Display
+	xOpenDisplay        func(display_name *byte) Display
 	xCloseDisplay       func(display Display)
 	xDefaultRootWindow  func(display Display) Window
 	xCreateSimpleWindow func(display Display, parent Window, x, y int, width, height, border_width uint, border, background uintptr) Window
@@ -84,12 +122,38 @@ var (
 	xNextEvent          func(display Display, event *XEvent)
 	xChangeProperty     func(display Display, w Window, property Atom, typ Atom, Format int, mode int, data *byte, nelements int) int
 	xSendEvent          func(display Display, w Window, propagate Bool, event_mask int64, event *XEvent)
-	xGetWindowProperty  func(display Display, w Window, property Atom, long_offset, long_length int64, delete Bool, req_type Atom, actual_type_return *Atom, actual_format_return *int, nitems_return *uint64, bytes_after_return *uint64, prop_return **byte) int
+	xGetWindowProperty  func(display Display, w Window, property Atom, long_offset, long_length int64, delete Bool, req_type Atom, actual_type_return *Atom, actual_format_return *int32, nitems_return *uint64, bytes_after_return *uint64, prop_return **byte) int
 	xFree               func(data unsafe.Pointer)
 	xDeleteProperty     func(display Display, w Window, property Atom)
 	xConvertSelection   func(display Display, selection Atom, target Atom, property Atom, requestor Window, time Time)
+	xPending            func(display Display) int
+	xGetAtomName        func(display Display, atom Atom) *byte
+	xFlush              func(display Display)
+	xSetAuthorization   func(name *byte, namelen int, data *byte, datalen int)
+	xSelectInput        func(display Display, w Window, event_mask int64)
+	xSync               func(display Display, discard Bool)
+	xNextRequest        func(display Display) uintptr
+	xSetErrorHandler    func(handler uintptr) uintptr
+	xSetIOErrorHandler  func(handler uintptr) uintptr
+	// xSetIOErrorExitHandler is nil before libX11 1.7, where losing the
+	// connection exits the process.
+	xSetIOErrorExitHandler func(display Display, handler uintptr, data uintptr)
+	xConnectionNumber      func(display Display) int32
+
+	// poll comes from libc, which libX11 loaded.
+	poll func(fds *pollFd, nfds uintptr, timeout int32) int32
 )
 
+// pollFd mirrors struct pollfd.
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+// pollIn is the POLLIN event.
+const pollIn = 0x1
+
 var helpmsg = `%w: Failed to initialize the X11 display, and the clipboard package
 will not work properly. Install the following dependency may help:
 
@@ -112,85 +176,234 @@ initialize a virtual frame buffer:
 Then this package should be ready to use.
 `
 
-func initialize() error {
-	var err error
-	
-	// Try common library paths for libX11
+// libX11Paths are the default paths to load libX11 from.
+var libX11Paths = []string{
 	// Linux systems: libX11.so.6, libX11.so
 	// FreeBSD often has X11 in /usr/local/lib or /usr/X11R6/lib
-	libPaths := []string{
-		"libX11.so.6",           // versioned library (Linux, some BSD)
-		"libX11.so",             // generic library (Linux, BSD)
-		"/usr/local/lib/libX11.so.6",  // FreeBSD, OpenBSD
-		"/usr/local/lib/libX11.so",
-		"/usr/X11R6/lib/libX11.so.6",  // Older BSD systems
-		"/usr/X11R6/lib/libX11.so",
+	"libX11.so.6",                // versioned library (Linux, some BSD)
+	"libX11.so",                  // generic library (Linux, BSD)
+	"/usr/local/lib/libX11.so.6", // FreeBSD, OpenBSD
+	"/usr/local/lib/libX11.so",
+	"/usr/X11R6/lib/libX11.so.6", // Older BSD systems
+	"/usr/X11R6/lib/libX11.so",
+}
+
+// libX11Mu guards loading libX11, which happens once per process.
+var libX11Mu sync.Mutex
+
+// loadLibX11 loads libX11 from the first of paths that works, unless it is
+// already loaded.
+func loadLibX11(paths []string) error {
+	libX11Mu.Lock()
+	defer libX11Mu.Unlock()
+
+	if libX11 != 0 {
+		return nil
 	}
-	
-	for _, path := range libPaths {
-		libX11, err = purego.Dlopen(path, purego.RTLD_LAZY|purego.RTLD_GLOBAL)
+
+	var lib uintptr
+	err := ErrUnavailable
+	for _, path := range paths {
+		lib, err = purego.Dlopen(path, purego.RTLD_LAZY|purego.RTLD_GLOBAL)
 		if err == nil {
 			break
 		}
 	}
-	
 	if err != nil {
-		return fmt.Errorf(helpmsg, ErrUnavailable)
+		return err
 	}
 
 	// Load all X11 functions
-	purego.RegisterLibFunc(&xOpenDisplay, libX11, "XOpenDisplay")
-	purego.RegisterLibFunc(&xCloseDisplay, libX11, "XCloseDisplay")
-	purego.RegisterLibFunc(&xDefaultRootWindow, libX11, "XDefaultRootWindow")
-	purego.RegisterLibFunc(&xCreateSimpleWindow, libX11, "XCreateSimpleWindow")
-	purego.RegisterLibFunc(&xInternAtom, libX11, "XInternAtom")
-	purego.RegisterLibFunc(&xSetSelectionOwner, libX11, "XSetSelectionOwner")
-	purego.RegisterLibFunc(&xGetSelectionOwner, libX11, "XGetSelectionOwner")
-	purego.RegisterLibFunc(&xNextEvent, libX11, "XNextEvent")
-	purego.RegisterLibFunc(&xChangeProperty, libX11, "XChangeProperty")
-	purego.RegisterLibFunc(&xSendEvent, libX11, "XSendEvent")
-	purego.RegisterLibFunc(&xGetWindowProperty, libX11, "XGetWindowProperty")
-	purego.RegisterLibFunc(&xFree, libX11, "XFree")
-	purego.RegisterLibFunc(&xDeleteProperty, libX11, "XDeleteProperty")
-	purego.RegisterLibFunc(&xConvertSelection, libX11, "XConvertSelection")
+	purego.RegisterLibFunc(&xOpenDisplay, lib, "XOpenDisplay")
+	purego.RegisterLibFunc(&xCloseDisplay, lib, "XCloseDisplay")
+	purego.RegisterLibFunc(&xDefaultRootWindow, lib, "XDefaultRootWindow")
+	purego.RegisterLibFunc(&xCreateSimpleWindow, lib, "XCreateSimpleWindow")
+	purego.RegisterLibFunc(&xInternAtom, lib, "XInternAtom")
+	purego.RegisterLibFunc(&xSetSelectionOwner, lib, "XSetSelectionOwner")
+	purego.RegisterLibFunc(&xGetSelectionOwner, lib, "XGetSelectionOwner")
+	purego.RegisterLibFunc(&xNextEvent, lib, "XNextEvent")
+	purego.RegisterLibFunc(&xChangeProperty, lib, "XChangeProperty")
+	purego.RegisterLibFunc(&xSendEvent, lib, "XSendEvent")
+	purego.RegisterLibFunc(&xGetWindowProperty, lib, "XGetWindowProperty")
+	purego.RegisterLibFunc(&xFree, lib, "XFree")
+	purego.RegisterLibFunc(&xDeleteProperty, lib, "XDeleteProperty")
+	purego.RegisterLibFunc(&xConvertSelection, lib, "XConvertSelection")
+	purego.RegisterLibFunc(&xPending, lib, "XPending")
+	purego.RegisterLibFunc(&xGetAtomName, lib, "XGetAtomName")
+	purego.RegisterLibFunc(&xFlush, lib, "XFlush")
+	purego.RegisterLibFunc(&xSetAuthorization, lib, "XSetAuthorization")
+	purego.RegisterLibFunc(&xSelectInput, lib, "XSelectInput")
+	purego.RegisterLibFunc(&xSync, lib, "XSync")
+	purego.RegisterLibFunc(&xNextRequest, lib, "XNextRequest")
+	purego.RegisterLibFunc(&xSetErrorHandler, lib, "XSetErrorHandler")
+	purego.RegisterLibFunc(&xSetIOErrorHandler, lib, "XSetIOErrorHandler")
+	purego.RegisterLibFunc(&xConnectionNumber, lib, "XConnectionNumber")
+	purego.RegisterLibFunc(&poll, purego.RTLD_DEFAULT, "poll")
+	if sym, err := purego.Dlsym(lib, "XSetIOErrorExitHandler"); err == nil {
+		purego.RegisterFunc(&xSetIOErrorExitHandler, sym)
+	}
 
-	// Test if we can open display
-	var display Display
-	for i := 0; i < 42; i++ {
-		display = xOpenDisplay(0)
-		if display != 0 {
-			break
+	// The default handlers print the error and exit the process.
+	xSetErrorHandler(purego.NewCallback(xlibErrorHandler))
+	xSetIOErrorHandler(purego.NewCallback(xlibIOErrorHandler))
+	xlibIOErrorExit = purego.NewCallback(xlibIOErrorExitHandler)
+
+	libX11 = lib
+	return nil
+}
+
+// newRecentX11Backend is newX11Backend for automatic detection. It rejects
+// libX11 older than 1.7, which exits the process when the X server goes away,
+// so that xproto is tried first.
+func newRecentX11Backend(o *options) (backend, error) {
+	if err := loadLibX11(append(o.libX11[:len(o.libX11):len(o.libX11)], libX11Paths...)); err != nil {
+		return nil, fmt.Errorf(helpmsg, ErrUnavailable)
+	}
+	if xSetIOErrorExitHandler == nil {
+		return nil, fmt.Errorf("%w: libX11 is older than 1.7 and can't survive losing the X server, xproto is preferred", ErrUnavailable)
+	}
+	return newX11Backend(o)
+}
+
+// newX11Backend loads libX11 and makes sure the X display can be opened.
+func newX11Backend(o *options) (backend, error) {
+	if err := loadLibX11(append(o.libX11[:len(o.libX11):len(o.libX11)], libX11Paths...)); err != nil {
+		return nil, fmt.Errorf(helpmsg, ErrUnavailable)
+	}
+
+	b := x11Backend{name: "x11", display: o.display, libX11: o.libX11}
+	var auth *xauthEntry
+	if o.xauthority != "" {
+		entries, err := readXauthority(o.xauthority)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
 		}
+		if auth, err = findXauth(entries, b.displayName()); err != nil {
+			return nil, err
+		}
+		b.xauthority = o.xauthority
 	}
-	if display == 0 {
-		return fmt.Errorf(helpmsg, ErrUnavailable)
+	b.dial = func() (xDisplay, error) {
+		return openXlibDisplay(o.display, auth)
 	}
-	xCloseDisplay(display)
 
-	return nil
+	// Test if we can open display
+	d, err := b.dial()
+	if err != nil {
+		return nil, fmt.Errorf(helpmsg, ErrUnavailable)
+	}
+	d.close()
+
+	return b, nil
 }
 
-func read(t Format) ([]byte, error) {
-	var atomType string
-	switch t {
-	case Text:
-		atomType = "UTF8_STRING"
-	case Image:
-		atomType = "image/png"
-	default:
-		return nil, ErrUnsupported
+// authMu serializes connections made with an explicit cookie, since
+// XSetAuthorization applies to the whole process.
+var authMu sync.Mutex
+
+// xlibDisplay is a connection to an X server made through libX11.
+type xlibDisplay struct {
+	display Display
+	root    Window
+
+	// The fields below are set by the error handlers, which libX11 calls
+	// on the thread making the failing call.
+
+	// errs holds the X errors received since the last sync.
+	errs []xlibError
+	// lost is set once the connection to the server is lost.
+	lost bool
+}
+
+// xlibError is an X error along with the serial number of the request that
+// caused it.
+type xlibError struct {
+	serial uintptr
+	err    *xError
+}
+
+var (
+	xlibDisplaysMu sync.Mutex
+	// xlibDisplays maps the open displays to their connection, for the
+	// error handlers.
+	xlibDisplays = make(map[Display]*xlibDisplay)
+	// xlibIOErrorExit is the callback installed with XSetIOErrorExitHandler.
+	xlibIOErrorExit uintptr
+)
+
+// lookupXlibDisplay returns the connection of an open display, or nil.
+func lookupXlibDisplay(display Display) *xlibDisplay {
+	xlibDisplaysMu.Lock()
+	defer xlibDisplaysMu.Unlock()
+	return xlibDisplays[display]
+}
+
+// xlibErrorHandler records X errors instead of exiting, so the operation that
+// caused them can report them.
+func xlibErrorHandler(display Display, event *XErrorEvent) int {
+	if d := lookupXlibDisplay(display); d != nil {
+		d.errs = append(d.errs, xlibError{event.serial, &xError{
+			code:     event.error_code,
+			opcode:   event.request_code,
+			resource: uint32(event.resourceid),
+		}})
+	}
+	return 0
+}
+
+// xlibIOErrorHandler marks the connection as lost. libX11 calls the exit
+// handler next, which exits the process unless we replaced it.
+func xlibIOErrorHandler(display Display) int {
+	if d := lookupXlibDisplay(display); d != nil {
+		d.lost = true
+	}
+	return 0
+}
+
+// xlibIOErrorExitHandler replaces the default exit handler, so losing the
+// connection makes the following calls on the display fail instead.
+func xlibIOErrorExitHandler(display Display, data uintptr) {}
+
+// sync waits until the server processed the requests made so far, and
+// returns the first error caused by a request at or after serial.
+func (d *xlibDisplay) sync(serial uintptr) error {
+	if d.lost {
+		return errLostConnection
+	}
+	xSync(d.display, 0)
+	if d.lost {
+		return errLostConnection
 	}
 
-	return readX11(atomType)
+	errs := d.errs
+	d.errs = nil
+	for _, e := range errs {
+		if e.serial >= serial {
+			return fmt.Errorf("%w: %v", ErrUnavailable, e.err)
+		}
+	}
+	return nil
 }
 
-func readX11(atomType string) ([]byte, error) {
-	runtime.LockOSThread()
-	defer runtime.UnlockOSThread()
+// openXlibDisplay opens a connection to the X display, $DISPLAY if name is
+// empty, retrying a few times since the server might be busy. If auth isn't
+// nil, it's used instead of the cookie libX11 would find.
+func openXlibDisplay(name string, auth *xauthEntry) (xDisplay, error) {
+	var cname *byte
+	if name != "" {
+		cname = unsafe.StringData(name + "\x00")
+	}
+	if auth != nil {
+		authMu.Lock()
+		defer authMu.Unlock()
+
+		xSetAuthorization(unsafe.StringData(auth.name), len(auth.name), unsafe.SliceData(auth.data), len(auth.data))
+		defer xSetAuthorization(nil, 0, nil, 0)
+	}
 
 	var display Display
 	for i := 0; i < 42; i++ {
-		display = xOpenDisplay(0)
+		display = xOpenDisplay(cname)
 		if display != 0 {
 			break
 		}
@@ -198,190 +411,197 @@ func readX11(atomType string) ([]byte, error) {
 	if display == 0 {
 		return nil, ErrUnavailable
 	}
-	defer xCloseDisplay(display)
+	if xSetIOErrorExitHandler != nil {
+		xSetIOErrorExitHandler(display, xlibIOErrorExit, 0)
+	}
 
-	root := xDefaultRootWindow(display)
-	window := xCreateSimpleWindow(display, root, 0, 0, 1, 1, 0, 0, 0)
+	d := &xlibDisplay{display: display, root: xDefaultRootWindow(display)}
+	xlibDisplaysMu.Lock()
+	xlibDisplays[display] = d
+	xlibDisplaysMu.Unlock()
+	return d, nil
+}
 
-	sel := xInternAtom(display, "CLIPBOARD", 0)
-	prop := xInternAtom(display, "GOLANG_DESIGN_DATA", 0)
-	target := xInternAtom(display, atomType, 1)
+func (d *xlibDisplay) close() {
+	xCloseDisplay(d.display)
 
-	if target == None {
-		return nil, ErrUnsupported
+	xlibDisplaysMu.Lock()
+	delete(xlibDisplays, d.display)
+	xlibDisplaysMu.Unlock()
+}
+
+func (d *xlibDisplay) createWindow() Window {
+	window := xCreateSimpleWindow(d.display, d.root, 0, 0, 1, 1, 0, 0, 0)
+	xSelectInput(d.display, window, PropertyChangeMask)
+	return window
+}
+
+func (d *xlibDisplay) internAtom(name string, onlyIfExists bool) Atom {
+	var only Bool
+	if onlyIfExists {
+		only = 1
 	}
+	return xInternAtom(d.display, name, only)
+}
 
-	xConvertSelection(display, sel, target, prop, window, CurrentTime)
+func (d *xlibDisplay) atomName(atom Atom) string {
+	p := xGetAtomName(d.display, atom)
+	if p == nil {
+		return ""
+	}
+	defer xFree(unsafe.Pointer(p))
 
-	var event XEvent
-	for {
-		xNextEvent(display, &event)
-		if event.typ != SelectionNotify {
-			continue
-		}
-		break
+	var n int
+	for *(*byte)(unsafe.Add(unsafe.Pointer(p), n)) != 0 {
+		n++
 	}
+	return string(unsafe.Slice(p, n))
+}
+
+func (d *xlibDisplay) setSelectionOwner(sel Atom, owner Window, t Time) {
+	xSetSelectionOwner(d.display, sel, owner, t)
+}
 
-	// Cast event to XSelectionEvent
-	sev := (*XSelectionEvent)(unsafe.Pointer(&event))
+func (d *xlibDisplay) selectionOwner(sel Atom) Window {
+	return xGetSelectionOwner(d.display, sel)
+}
 
-	if sev.property == None || sev.selection != sel || sev.property != prop {
-		return nil, ErrUnavailable
+func (d *xlibDisplay) convertSelection(sel, target, prop Atom, requestor Window, t Time) {
+	xConvertSelection(d.display, sel, target, prop, requestor, t)
+}
+
+// changeProperty replaces a window property. libX11 takes items of format 32
+// as C longs, so they're widened first.
+func (d *xlibDisplay) changeProperty(w Window, prop, typ Atom, format int, data []byte) error {
+	n := len(data)
+	switch format {
+	case 16:
+		n /= 2
+	case 32:
+		n /= 4
+		longs := make([]uintptr, n)
+		for i := range longs {
+			longs[i] = uintptr(binary.NativeEndian.Uint32(data[i*4:]))
+		}
+		if n > 0 {
+			data = unsafe.Slice((*byte)(unsafe.Pointer(&longs[0])), n*int(unsafe.Sizeof(uintptr(0))))
+		}
 	}
+	serial := xNextRequest(d.display)
+	xChangeProperty(d.display, w, prop, typ, format, PropModeReplace, unsafe.SliceData(data), n)
+	return d.sync(serial)
+}
+
+func (d *xlibDisplay) touchProperty(w Window, prop, typ Atom) {
+	xChangeProperty(d.display, w, prop, typ, 8, PropModeAppend, nil, 0)
+}
 
+// getProperty returns the type, format and data of a window property. libX11
+// returns items of format 32 as C longs, they're narrowed to 32 bits.
+func (d *xlibDisplay) getProperty(w Window, prop Atom) (Atom, int, []byte, bool) {
 	var actual Atom
-	var Format int
+	var format int32
 	var nitems, bytesAfter uint64
 	var data *byte
 
-	ret := xGetWindowProperty(sev.display, sev.requestor, sev.property,
+	ret := xGetWindowProperty(d.display, w, prop,
 		0, ^int64(0), 0, AnyPropertyType,
-		&actual, &Format, &nitems, &bytesAfter, &data)
+		&actual, &format, &nitems, &bytesAfter, &data)
 
 	if ret != Success || data == nil {
-		return nil, ErrUnavailable
+		return None, 0, nil, false
 	}
 	defer xFree(unsafe.Pointer(data))
 
 	if nitems == 0 {
-		return nil, nil
+		return actual, int(format), nil, true
 	}
 
-	// Copy data to Go slice
-	result := make([]byte, nitems)
-	dataSlice := unsafe.Slice(data, nitems)
-	copy(result, dataSlice)
-
-	xDeleteProperty(sev.display, sev.requestor, sev.property)
-
-	return result, nil
-}
-
-func write(t Format, buf []byte) (<-chan struct{}, error) {
-	var atomType string
-	switch t {
-	case Text:
-		atomType = "UTF8_STRING"
-	case Image:
-		atomType = "image/png"
+	var result []byte
+	switch format {
+	case 16:
+		result = make([]byte, nitems*2)
+		copy(result, unsafe.Slice(data, nitems*2))
+	case 32:
+		longs := unsafe.Slice((*uintptr)(unsafe.Pointer(data)), nitems)
+		result = make([]byte, nitems*4)
+		for i, l := range longs {
+			binary.NativeEndian.PutUint32(result[i*4:], uint32(l))
+		}
 	default:
-		return nil, ErrUnsupported
+		result = make([]byte, nitems)
+		copy(result, unsafe.Slice(data, nitems))
 	}
 
-	done := make(chan struct{}, 1)
-
-	go func() {
-		runtime.LockOSThread()
-		defer runtime.UnlockOSThread()
-
-		var display Display
-		for i := 0; i < 42; i++ {
-			display = xOpenDisplay(0)
-			if display != 0 {
-				break
-			}
-		}
-		if display == 0 {
-			close(done)
-			return
-		}
-		defer xCloseDisplay(display)
+	return actual, int(format), result, true
+}
 
-		root := xDefaultRootWindow(display)
-		window := xCreateSimpleWindow(display, root, 0, 0, 1, 1, 0, 0, 0)
+func (d *xlibDisplay) deleteProperty(w Window, prop Atom) {
+	xDeleteProperty(d.display, w, prop)
+}
 
-		sel := xInternAtom(display, "CLIPBOARD", 0)
-		atomString := xInternAtom(display, "UTF8_STRING", 0)
-		atomImage := xInternAtom(display, "image/png", 0)
-		targetsAtom := xInternAtom(display, "TARGETS", 0)
-		xaAtom := xInternAtom(display, "ATOM", 0)
+func (d *xlibDisplay) sendEvent(ev xEvent) {
+	// The event must be as large as the XEvent union, libX11 copies all of
+	// it.
+	var event XEvent
+	*(*XSelectionEvent)(unsafe.Pointer(&event)) = XSelectionEvent{
+		typ:       SelectionNotify,
+		display:   d.display,
+		requestor: ev.requestor,
+		selection: ev.selection,
+		target:    ev.target,
+		property:  ev.property,
+		time:      ev.time,
+	}
+	xSendEvent(d.display, ev.requestor, 0, 0, &event)
+}
 
-		target := xInternAtom(display, atomType, 1)
-		if target == None {
-			close(done)
-			return
-		}
+func (d *xlibDisplay) flush() {
+	xFlush(d.display)
+}
 
-		xSetSelectionOwner(display, sel, window, CurrentTime)
-		if xGetSelectionOwner(display, sel) != window {
-			close(done)
-			return
+// nextEvent returns the next event. XNextEvent can neither time out nor
+// tell that the connection was lost, so we wait for the connection to be
+// readable and let XPending read the events.
+func (d *xlibDisplay) nextEvent(deadline time.Time) (xEvent, error) {
+	for xPending(d.display) == 0 {
+		if d.lost {
+			return xEvent{}, errLostConnection
 		}
 
-		var event XEvent
-		for {
-			xNextEvent(display, &event)
-
-			switch event.typ {
-			case SelectionClear:
-				close(done)
-				return
-
-			case SelectionRequest:
-				req := (*XSelectionRequestEvent)(unsafe.Pointer(&event))
-				if req.selection != sel {
-					continue
-				}
-
-				var selEvent XSelectionEvent
-				selEvent.typ = SelectionNotify
-				selEvent.display = req.display
-				selEvent.requestor = req.requestor
-				selEvent.selection = req.selection
-				selEvent.target = req.target
-				selEvent.property = req.property
-				selEvent.time = req.time
-
-				if req.target == atomString && target == atomString {
-					if len(buf) > 0 {
-						xChangeProperty(selEvent.display, selEvent.requestor, selEvent.property,
-							atomString, 8, PropModeReplace, &buf[0], len(buf))
-					}
-				} else if req.target == atomImage && target == atomImage {
-					if len(buf) > 0 {
-						xChangeProperty(selEvent.display, selEvent.requestor, selEvent.property,
-							atomImage, 8, PropModeReplace, &buf[0], len(buf))
-					}
-				} else if req.target == targetsAtom {
-					targets := []Atom{atomString, atomImage}
-					xChangeProperty(selEvent.display, selEvent.requestor, selEvent.property,
-						xaAtom, 32, PropModeReplace, (*byte)(unsafe.Pointer(&targets[0])), len(targets))
-				} else {
-					selEvent.property = None
-				}
-
-				xSendEvent(selEvent.display, selEvent.requestor, 0, 0, (*XEvent)(unsafe.Pointer(&selEvent)))
+		timeout := int32(-1)
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return xEvent{}, errTimeout
 			}
+			timeout = int32((left + time.Millisecond - 1) / time.Millisecond)
 		}
-	}()
-
-	return done, nil
-}
-
-func watch(ctx context.Context, t Format) <-chan []byte {
-	recv := make(chan []byte, 1)
-	ticker := time.NewTicker(time.Second)
-	last, _ := read(t)
-
-	go func() {
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				close(recv)
-				return
-			case <-ticker.C:
-				b, _ := read(t)
-				if b == nil {
-					continue
-				}
-				if !bytes.Equal(last, b) {
-					recv <- b
-					last = b
-				}
-			}
-		}
-	}()
+		fd := pollFd{fd: xConnectionNumber(d.display), events: pollIn}
+		poll(&fd, 1, timeout)
+	}
 
-	return recv
+	var event XEvent
+	xNextEvent(d.display, &event)
+	if d.lost {
+		return xEvent{}, errLostConnection
+	}
+
+	switch event.typ {
+	case SelectionNotify:
+		e := (*XSelectionEvent)(unsafe.Pointer(&event))
+		return xEvent{typ: SelectionNotify, time: e.time, requestor: e.requestor,
+			selection: e.selection, target: e.target, property: e.property}, nil
+	case SelectionRequest:
+		e := (*XSelectionRequestEvent)(unsafe.Pointer(&event))
+		return xEvent{typ: SelectionRequest, time: e.time, owner: e.owner, requestor: e.requestor,
+			selection: e.selection, target: e.target, property: e.property}, nil
+	case SelectionClear:
+		e := (*XSelectionClearEvent)(unsafe.Pointer(&event))
+		return xEvent{typ: SelectionClear, time: e.time, owner: e.window, selection: e.selection}, nil
+	case PropertyNotify:
+		e := (*XPropertyEvent)(unsafe.Pointer(&event))
+		return xEvent{typ: PropertyNotify, time: e.time, window: e.window, property: e.atom, state: int(e.state)}, nil
+	}
+	return xEvent{typ: int(event.typ)}, nil
 }
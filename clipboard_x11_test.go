@@ -0,0 +1,296 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runClipboardManager runs a minimal clipboard manager that answers
+// SAVE_TARGETS requests by taking a snapshot of the clipboard. Saved
+// snapshots are sent on the returned channel.
+func runClipboardManager(t *testing.T, ctx context.Context) <-chan *Contents {
+	t.Helper()
+
+	saved := make(chan *Contents, 1)
+	ready := make(chan struct{})
+
+	b, ok := defaultClipboard().b.(x11Backend)
+	if !ok {
+		t.Skip("Not using an X11 backend")
+	}
+
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		d, err := b.dial()
+		if err != nil {
+			close(ready)
+			return
+		}
+		defer d.close()
+
+		window := d.createWindow()
+		managerSel := d.internAtom("CLIPBOARD_MANAGER", false)
+		saveTargets := d.internAtom("SAVE_TARGETS", false)
+
+		d.setSelectionOwner(managerSel, window, CurrentTime)
+		d.flush()
+		close(ready)
+
+		for ctx.Err() == nil {
+			req, err := d.nextEvent(time.Now().Add(50 * time.Millisecond))
+			if err != nil || req.typ != SelectionRequest {
+				continue
+			}
+
+			reply := xEvent{
+				typ:       SelectionNotify,
+				time:      req.time,
+				requestor: req.requestor,
+				selection: req.selection,
+				target:    req.target,
+			}
+			if req.target == saveTargets {
+				if c, err := b.snapshot(ClipboardSelection); err == nil {
+					saved <- c
+					reply.property = req.property
+				}
+			}
+
+			d.sendEvent(reply)
+			d.flush()
+		}
+	}()
+
+	<-ready
+	return saved
+}
+
+func TestFlushWithoutManager(t *testing.T) {
+	_, display := startFakeX(t)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("No manager")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			ok, err := c.Flush(context.Background())
+			if err != nil {
+				t.Fatalf("Flush failed: %v", err)
+			}
+			if ok {
+				t.Fatal("Flush reported success without a clipboard manager")
+			}
+		})
+	}
+}
+
+func TestFlushUnresponsiveManager(t *testing.T) {
+	s, display := startFakeX(t)
+	asked := make(chan struct{}, 2)
+	s.own("CLIPBOARD_MANAGER", func(fakeXRequest) {
+		asked <- struct{}{}
+	})
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Never saved")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			flushed := make(chan error, 1)
+			go func() {
+				_, err := c.Flush(ctx)
+				flushed <- err
+			}()
+			<-asked
+
+			// Waiting for the manager doesn't block the clipboard.
+			if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "Never saved" {
+				t.Fatalf("Expected %q while flushing, got %q (%v)", "Never saved", data, err)
+			}
+			select {
+			case err := <-flushed:
+				t.Fatalf("Flush returned before the deadline: %v", err)
+			default:
+			}
+
+			if err := <-flushed; !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("Expected the deadline to pass, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFlushClipboardManager(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	saved := runClipboardManager(t, ctx)
+
+	testData := []byte("Saved by the manager")
+	if _, err := Text.Write(testData); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	ok, err := Flush(ctx)
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if !ok {
+		t.Fatal("Flush reported the manager didn't save the contents")
+	}
+
+	c := <-saved
+	for _, e := range c.Entries {
+		if e.Target == "UTF8_STRING" && string(e.Data) == string(testData) {
+			return
+		}
+	}
+	t.Fatalf("Manager didn't save %q, got %+v", testData, c.Entries)
+}
+
+func TestDetachedWrite(t *testing.T) {
+	_, display := startFakeX(t)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			SetDetached(true)
+			defer SetDetached(false)
+
+			// The owner of the previous subtest may not have seen it lost
+			// the selection yet.
+			owners := c.b.(x11Backend).owners()
+			previous := owners[ClipboardSelection].Load()
+
+			testData := []byte("Served by the helper")
+			changed, err := c.Write(ClipboardSelection, Text, testData)
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if o := owners[ClipboardSelection].Load(); o != nil && o != previous {
+				t.Fatal("Detached write took ownership in this process")
+			}
+
+			data, err := c.Read(ClipboardSelection, Text)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if string(data) != string(testData) {
+				t.Fatalf("Expected %q, got %q", testData, data)
+			}
+
+			// Taking over the clipboard stops the helper
+			SetDetached(false)
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Back in process")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			select {
+			case <-changed:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Timeout waiting for the helper to lose ownership")
+			}
+		})
+	}
+}
+
+func TestDetachedWriteHelperFails(t *testing.T) {
+	s, display := startFakeX(t)
+	clipboards := fakeXClipboards(t, display)
+	s.close()
+
+	SetDetached(true)
+	defer SetDetached(false)
+
+	for name, c := range clipboards {
+		t.Run(name, func(t *testing.T) {
+			_, err := c.Write(ClipboardSelection, Text, []byte("Never served"))
+			if !errors.Is(err, ErrUnavailable) || !strings.Contains(err.Error(), "helper failed") {
+				t.Fatalf("Expected the helper to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHelperNeedsArgument(t *testing.T) {
+	// The variable alone, inherited by another run of the binary, doesn't
+	// turn it into a helper.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), helperEnv+"="+selectionName(ClipboardSelection))
+	out, err := cmd.CombinedOutput()
+	if err != nil || !strings.Contains(string(out), "PASS") {
+		t.Fatalf("Expected the tests to run, got %q (%v)", out, err)
+	}
+}
+
+func TestPrimarySelection(t *testing.T) {
+	if _, err := Text.Write([]byte("Clipboard")); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+	if _, err := PrimarySelection.Write(Text, []byte("Primary")); err != nil {
+		t.Fatalf("PrimarySelection.Write failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	data, err := PrimarySelection.Read(Text)
+	if err != nil {
+		t.Fatalf("PrimarySelection.Read failed: %v", err)
+	}
+	if string(data) != "Primary" {
+		t.Fatalf("Expected %q from the primary selection, got %q", "Primary", data)
+	}
+
+	data, err = Text.Read()
+	if err != nil {
+		t.Fatalf("Text.Read failed: %v", err)
+	}
+	if string(data) != "Clipboard" {
+		t.Fatalf("Expected %q from the clipboard, got %q", "Clipboard", data)
+	}
+}
+
+func TestX11Options(t *testing.T) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		t.Skip("No X display")
+	}
+	t.Setenv("DISPLAY", "")
+
+	hostname, _ := os.Hostname()
+	_, number, _ := parseDisplay(display)
+	xauthority := writeXauthority(t, xauthEntry{xauthFamilyLocal, hostname, number, xauthCookie, []byte("0123456789abcdef")})
+
+	c, err := New(WithBackend("x11"), WithDisplay(display), WithXAuthority(xauthority), WithLibX11("/nonexistent/libX11.so"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("Explicit display")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := c.Read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "Explicit display" {
+		t.Fatalf("Expected %q, got %q", "Explicit display", data)
+	}
+
+	empty := writeXauthority(t)
+	if _, err := New(WithBackend("x11"), WithDisplay(display), WithXAuthority(empty)); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable without a cookie, got %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import "context"
+
+// ConnectionEvent reports a change in the connection to the display server
+// holding the clipboard.
+type ConnectionEvent struct {
+	// Connected is false when the connection was lost, and true once it is
+	// back.
+	Connected bool
+	// Err is the reason the connection was lost, nil when Connected is true.
+	Err error
+}
+
+// connectionReporter is implemented by backends whose connection to the
+// display server can be lost and regained.
+type connectionReporter interface {
+	// connectionEvents returns a channel receiving connection events until
+	// ctx is done, when it's closed.
+	connectionEvents(ctx context.Context) <-chan ConnectionEvent
+}
+
+// ConnectionEvents returns a channel that receives an event whenever the
+// clipboard loses or regains its connection to the display server. The
+// channel is closed when ctx is done. Events are dropped if the receiver
+// falls behind.
+//
+// On X11, the clipboard reconnects on its own after the X server restarts:
+// the data written by this process is offered again, unless another client
+// owns the selection by then, and watchers see the selection again. Other
+// backends don't hold a connection, and never send events.
+func ConnectionEvents(ctx context.Context) (<-chan ConnectionEvent, error) {
+	return defaultClipboard().ConnectionEvents(ctx)
+}
+
+// ConnectionEvents returns a channel that receives an event whenever the
+// clipboard loses or regains its connection to the display server. See
+// [ConnectionEvents].
+func (c *Clipboard) ConnectionEvents(ctx context.Context) (<-chan ConnectionEvent, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if r, ok := c.b.(connectionReporter); ok {
+		return r.connectionEvents(ctx), nil
+	}
+
+	ch := make(chan ConnectionEvent)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
@@ -0,0 +1,400 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// COMPOUND_TEXT is the ISO 2022 based text encoding of X11, still the only
+// one some legacy applications offer or understand, such as Emacs, Motif
+// applications and xterm in some locales. Text starts with ASCII in GL and
+// the right half of Latin-1 in GR, and escape sequences designate other
+// character sets to either half.
+//
+// Single byte character sets and UTF-8 segments are decoded. Multibyte
+// Asian character sets would need large tables, their characters decode to
+// U+FFFD.
+
+const (
+	ctextESC = 0x1b
+	ctextCSI = 0x9b
+	ctextSTX = 0x02
+)
+
+// iso8859 holds the right half of the ISO 8859 parts supported besides
+// Latin-1, keyed by the final byte of their 96-character set designation.
+// Unassigned positions hold U+FFFD.
+var iso8859 = map[byte]*[96]rune{
+	// Latin-2, ISO 8859-2.
+	'B': {
+		0x00a0, 0x0104, 0x02d8, 0x0141, 0x00a4, 0x013d, 0x015a, 0x00a7, 0x00a8, 0x0160, 0x015e, 0x0164,
+		0x0179, 0x00ad, 0x017d, 0x017b, 0x00b0, 0x0105, 0x02db, 0x0142, 0x00b4, 0x013e, 0x015b, 0x02c7,
+		0x00b8, 0x0161, 0x015f, 0x0165, 0x017a, 0x02dd, 0x017e, 0x017c, 0x0154, 0x00c1, 0x00c2, 0x0102,
+		0x00c4, 0x0139, 0x0106, 0x00c7, 0x010c, 0x00c9, 0x0118, 0x00cb, 0x011a, 0x00cd, 0x00ce, 0x010e,
+		0x0110, 0x0143, 0x0147, 0x00d3, 0x00d4, 0x0150, 0x00d6, 0x00d7, 0x0158, 0x016e, 0x00da, 0x0170,
+		0x00dc, 0x00dd, 0x0162, 0x00df, 0x0155, 0x00e1, 0x00e2, 0x0103, 0x00e4, 0x013a, 0x0107, 0x00e7,
+		0x010d, 0x00e9, 0x0119, 0x00eb, 0x011b, 0x00ed, 0x00ee, 0x010f, 0x0111, 0x0144, 0x0148, 0x00f3,
+		0x00f4, 0x0151, 0x00f6, 0x00f7, 0x0159, 0x016f, 0x00fa, 0x0171, 0x00fc, 0x00fd, 0x0163, 0x02d9,
+	},
+	// Latin-3, ISO 8859-3.
+	'C': {
+		0x00a0, 0x0126, 0x02d8, 0x00a3, 0x00a4, 0xfffd, 0x0124, 0x00a7, 0x00a8, 0x0130, 0x015e, 0x011e,
+		0x0134, 0x00ad, 0xfffd, 0x017b, 0x00b0, 0x0127, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x0125, 0x00b7,
+		0x00b8, 0x0131, 0x015f, 0x011f, 0x0135, 0x00bd, 0xfffd, 0x017c, 0x00c0, 0x00c1, 0x00c2, 0xfffd,
+		0x00c4, 0x010a, 0x0108, 0x00c7, 0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf,
+		0xfffd, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x0120, 0x00d6, 0x00d7, 0x011c, 0x00d9, 0x00da, 0x00db,
+		0x00dc, 0x016c, 0x015c, 0x00df, 0x00e0, 0x00e1, 0x00e2, 0xfffd, 0x00e4, 0x010b, 0x0109, 0x00e7,
+		0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef, 0xfffd, 0x00f1, 0x00f2, 0x00f3,
+		0x00f4, 0x0121, 0x00f6, 0x00f7, 0x011d, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x016d, 0x015d, 0x02d9,
+	},
+	// Latin-4, ISO 8859-4.
+	'D': {
+		0x00a0, 0x0104, 0x0138, 0x0156, 0x00a4, 0x0128, 0x013b, 0x00a7, 0x00a8, 0x0160, 0x0112, 0x0122,
+		0x0166, 0x00ad, 0x017d, 0x00af, 0x00b0, 0x0105, 0x02db, 0x0157, 0x00b4, 0x0129, 0x013c, 0x02c7,
+		0x00b8, 0x0161, 0x0113, 0x0123, 0x0167, 0x014a, 0x017e, 0x014b, 0x0100, 0x00c1, 0x00c2, 0x00c3,
+		0x00c4, 0x00c5, 0x00c6, 0x012e, 0x010c, 0x00c9, 0x0118, 0x00cb, 0x0116, 0x00cd, 0x00ce, 0x012a,
+		0x0110, 0x0145, 0x014c, 0x0136, 0x00d4, 0x00d5, 0x00d6, 0x00d7, 0x00d8, 0x0172, 0x00da, 0x00db,
+		0x00dc, 0x0168, 0x016a, 0x00df, 0x0101, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x012f,
+		0x010d, 0x00e9, 0x0119, 0x00eb, 0x0117, 0x00ed, 0x00ee, 0x012b, 0x0111, 0x0146, 0x014d, 0x0137,
+		0x00f4, 0x00f5, 0x00f6, 0x00f7, 0x00f8, 0x0173, 0x00fa, 0x00fb, 0x00fc, 0x0169, 0x016b, 0x02d9,
+	},
+	// Greek, ISO 8859-7.
+	'F': {
+		0x00a0, 0x2018, 0x2019, 0x00a3, 0x20ac, 0x20af, 0x00a6, 0x00a7, 0x00a8, 0x00a9, 0x037a, 0x00ab,
+		0x00ac, 0x00ad, 0xfffd, 0x2015, 0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x0384, 0x0385, 0x0386, 0x00b7,
+		0x0388, 0x0389, 0x038a, 0x00bb, 0x038c, 0x00bd, 0x038e, 0x038f, 0x0390, 0x0391, 0x0392, 0x0393,
+		0x0394, 0x0395, 0x0396, 0x0397, 0x0398, 0x0399, 0x039a, 0x039b, 0x039c, 0x039d, 0x039e, 0x039f,
+		0x03a0, 0x03a1, 0xfffd, 0x03a3, 0x03a4, 0x03a5, 0x03a6, 0x03a7, 0x03a8, 0x03a9, 0x03aa, 0x03ab,
+		0x03ac, 0x03ad, 0x03ae, 0x03af, 0x03b0, 0x03b1, 0x03b2, 0x03b3, 0x03b4, 0x03b5, 0x03b6, 0x03b7,
+		0x03b8, 0x03b9, 0x03ba, 0x03bb, 0x03bc, 0x03bd, 0x03be, 0x03bf, 0x03c0, 0x03c1, 0x03c2, 0x03c3,
+		0x03c4, 0x03c5, 0x03c6, 0x03c7, 0x03c8, 0x03c9, 0x03ca, 0x03cb, 0x03cc, 0x03cd, 0x03ce, 0xfffd,
+	},
+	// Cyrillic, ISO 8859-5.
+	'L': {
+		0x00a0, 0x0401, 0x0402, 0x0403, 0x0404, 0x0405, 0x0406, 0x0407, 0x0408, 0x0409, 0x040a, 0x040b,
+		0x040c, 0x00ad, 0x040e, 0x040f, 0x0410, 0x0411, 0x0412, 0x0413, 0x0414, 0x0415, 0x0416, 0x0417,
+		0x0418, 0x0419, 0x041a, 0x041b, 0x041c, 0x041d, 0x041e, 0x041f, 0x0420, 0x0421, 0x0422, 0x0423,
+		0x0424, 0x0425, 0x0426, 0x0427, 0x0428, 0x0429, 0x042a, 0x042b, 0x042c, 0x042d, 0x042e, 0x042f,
+		0x0430, 0x0431, 0x0432, 0x0433, 0x0434, 0x0435, 0x0436, 0x0437, 0x0438, 0x0439, 0x043a, 0x043b,
+		0x043c, 0x043d, 0x043e, 0x043f, 0x0440, 0x0441, 0x0442, 0x0443, 0x0444, 0x0445, 0x0446, 0x0447,
+		0x0448, 0x0449, 0x044a, 0x044b, 0x044c, 0x044d, 0x044e, 0x044f, 0x2116, 0x0451, 0x0452, 0x0453,
+		0x0454, 0x0455, 0x0456, 0x0457, 0x0458, 0x0459, 0x045a, 0x045b, 0x045c, 0x00a7, 0x045e, 0x045f,
+	},
+	// Latin-5, ISO 8859-9.
+	'M': {
+		0x00a0, 0x00a1, 0x00a2, 0x00a3, 0x00a4, 0x00a5, 0x00a6, 0x00a7, 0x00a8, 0x00a9, 0x00aa, 0x00ab,
+		0x00ac, 0x00ad, 0x00ae, 0x00af, 0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x00b6, 0x00b7,
+		0x00b8, 0x00b9, 0x00ba, 0x00bb, 0x00bc, 0x00bd, 0x00be, 0x00bf, 0x00c0, 0x00c1, 0x00c2, 0x00c3,
+		0x00c4, 0x00c5, 0x00c6, 0x00c7, 0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf,
+		0x011e, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7, 0x00d8, 0x00d9, 0x00da, 0x00db,
+		0x00dc, 0x0130, 0x015e, 0x00df, 0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7,
+		0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef, 0x011f, 0x00f1, 0x00f2, 0x00f3,
+		0x00f4, 0x00f5, 0x00f6, 0x00f7, 0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x0131, 0x015f, 0x00ff,
+	},
+	// Latin-9, ISO 8859-15.
+	'b': {
+		0x00a0, 0x00a1, 0x00a2, 0x00a3, 0x20ac, 0x00a5, 0x0160, 0x00a7, 0x0161, 0x00a9, 0x00aa, 0x00ab,
+		0x00ac, 0x00ad, 0x00ae, 0x00af, 0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x017d, 0x00b5, 0x00b6, 0x00b7,
+		0x017e, 0x00b9, 0x00ba, 0x00bb, 0x0152, 0x0153, 0x0178, 0x00bf, 0x00c0, 0x00c1, 0x00c2, 0x00c3,
+		0x00c4, 0x00c5, 0x00c6, 0x00c7, 0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf,
+		0x00d0, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7, 0x00d8, 0x00d9, 0x00da, 0x00db,
+		0x00dc, 0x00dd, 0x00de, 0x00df, 0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7,
+		0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef, 0x00f0, 0x00f1, 0x00f2, 0x00f3,
+		0x00f4, 0x00f5, 0x00f6, 0x00f7, 0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff,
+	},
+}
+
+// iso8859Finals is the order in which character sets are tried for runes
+// Latin-1 lacks when encoding.
+const iso8859Finals = "bBCDMFL"
+
+// iso8859Names maps the names of ISO 8859 parts used in extended segments to
+// their final bytes.
+var iso8859Names = map[string]byte{
+	"iso8859-1":  'A',
+	"iso8859-2":  'B',
+	"iso8859-3":  'C',
+	"iso8859-4":  'D',
+	"iso8859-5":  'L',
+	"iso8859-7":  'F',
+	"iso8859-9":  'M',
+	"iso8859-15": 'b',
+}
+
+// ctextCharset is a character set designated to GL or GR.
+type ctextCharset struct {
+	// width is the number of bytes per character.
+	width int
+	// decode returns the character for a byte with its high bit cleared,
+	// nil for unsupported sets.
+	decode func(c byte) rune
+}
+
+var (
+	ctextASCII  = ctextCharset{1, func(c byte) rune { return rune(c) }}
+	ctextLatin1 = ctextCharset{1, func(c byte) rune { return rune(c) | 0x80 }}
+)
+
+// ctext94 returns the 94-character set with the final byte f.
+func ctext94(f byte) ctextCharset {
+	switch f {
+	case 'B':
+		return ctextASCII
+	case 'J':
+		// JIS X 0201 Roman, ASCII with a yen sign and an overline.
+		return ctextCharset{1, func(c byte) rune {
+			switch c {
+			case 0x5c:
+				return 0xa5
+			case 0x7e:
+				return 0x203e
+			}
+			return rune(c)
+		}}
+	case 'I':
+		// JIS X 0201 Katakana.
+		return ctextCharset{1, func(c byte) rune {
+			if c < 0x21 || c > 0x5f {
+				return utf8.RuneError
+			}
+			return 0xff61 + rune(c-0x21)
+		}}
+	}
+	return ctextCharset{width: 1}
+}
+
+// ctext96 returns the 96-character set with the final byte f.
+func ctext96(f byte) ctextCharset {
+	if f == 'A' {
+		return ctextLatin1
+	}
+	if t, ok := iso8859[f]; ok {
+		return ctextCharset{1, func(c byte) rune { return t[c-0x20] }}
+	}
+	return ctextCharset{width: 1}
+}
+
+// decodeCompoundText converts COMPOUND_TEXT to UTF-8.
+func decodeCompoundText(data []byte) []byte {
+	gl, gr := ctextASCII, ctextLatin1
+	buf := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == ctextESC:
+			// Intermediate bytes followed by a final byte.
+			j := i + 1
+			for j < len(data) && data[j] >= 0x20 && data[j] <= 0x2f {
+				j++
+			}
+			if j == len(data) {
+				return buf
+			}
+			inter, final := string(data[i+1:j]), data[j]
+			i = j + 1
+
+			switch inter {
+			case "(":
+				gl = ctext94(final)
+			case ")":
+				gr = ctext94(final)
+			case "-":
+				gr = ctext96(final)
+			case "$", "$(":
+				gl = ctextCharset{width: 2}
+			case "$)":
+				gr = ctextCharset{width: 2}
+			case "%":
+				if final != 'G' {
+					continue
+				}
+				// UTF-8 until ESC % @.
+				end := bytes.Index(data[i:], []byte{ctextESC, '%', '@'})
+				if end < 0 {
+					end = len(data) - i
+				}
+				buf = append(buf, bytes.ToValidUTF8(data[i:i+end], []byte("\uFFFD"))...)
+				i += end + 3
+			case "%/":
+				// An extended segment: its length in two bytes, then the
+				// name of its encoding ended by STX, then the text.
+				if i+2 > len(data) {
+					return buf
+				}
+				n := int(data[i]&0x7f)<<7 | int(data[i+1]&0x7f)
+				seg := data[i+2 : min(i+2+n, len(data))]
+				i += 2 + len(seg)
+				buf = appendSegment(buf, seg)
+			}
+
+		case c == ctextCSI:
+			// Directionality controls: parameters, intermediates and a
+			// final byte. The text is kept in logical order.
+			i++
+			for i < len(data) && data[i] >= 0x20 && data[i] <= 0x3f {
+				i++
+			}
+			i++
+
+		case c == '\t' || c == '\n' || c == ' ':
+			buf = append(buf, c)
+			i++
+
+		case c >= 0x20 && c < 0x7f, c >= 0xa0:
+			set := gl
+			if c >= 0xa0 {
+				set = gr
+			}
+			if i+set.width > len(data) {
+				return buf
+			}
+			r := utf8.RuneError
+			if set.decode != nil {
+				r = set.decode(c & 0x7f)
+			}
+			buf = utf8.AppendRune(buf, r)
+			i += set.width
+
+		default:
+			// Other control characters aren't allowed.
+			i++
+		}
+	}
+	return buf
+}
+
+// appendSegment appends the text of an extended segment, if its encoding
+// is supported.
+func appendSegment(buf, seg []byte) []byte {
+	name, text, ok := bytes.Cut(seg, []byte{ctextSTX})
+	if !ok {
+		return buf
+	}
+	switch enc := strings.ToLower(string(name)); {
+	case enc == "utf-8":
+		return append(buf, bytes.ToValidUTF8(text, []byte("\uFFFD"))...)
+	case iso8859Names[enc] != 0:
+		set := ctext96(iso8859Names[enc])
+		for _, c := range text {
+			switch {
+			case c < 0x80:
+				buf = append(buf, c)
+			case c >= 0xa0:
+				buf = utf8.AppendRune(buf, set.decode(c&0x7f))
+			}
+		}
+		return buf
+	}
+	return utf8.AppendRune(buf, utf8.RuneError)
+}
+
+// iso8859Runes maps the runes of the supported ISO 8859 parts besides
+// Latin-1 to the final byte of their set and their byte.
+var iso8859Runes = sync.OnceValue(func() map[rune][2]byte {
+	m := make(map[rune][2]byte)
+	for i := len(iso8859Finals) - 1; i >= 0; i-- {
+		f := iso8859Finals[i]
+		for j, r := range iso8859[f] {
+			if r != utf8.RuneError {
+				m[r] = [2]byte{f, byte(j) + 0xa0}
+			}
+		}
+	}
+	return m
+})
+
+// encodeCompoundText converts UTF-8 text to COMPOUND_TEXT. Characters found
+// in the supported ISO 8859 parts use those, others are put in UTF-8
+// segments. Control characters other than tab and newline are dropped, as
+// COMPOUND_TEXT doesn't allow them.
+func encodeCompoundText(text []byte) []byte {
+	buf := make([]byte, 0, len(text))
+	gr := byte('A')
+	designate := func(f byte) {
+		if gr != f {
+			buf = append(buf, ctextESC, '-', f)
+			gr = f
+		}
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRune(text[i:])
+		switch {
+		case r == '\t' || r == '\n' || r >= 0x20 && r < 0x7f:
+			buf = append(buf, byte(r))
+		case r < 0xa0:
+		case r < 0x100:
+			designate('A')
+			buf = append(buf, byte(r))
+		default:
+			if p, ok := iso8859Runes()[r]; ok && size > 1 {
+				designate(p[0])
+				buf = append(buf, p[1])
+				break
+			}
+			// Put this and the following runes without a character set in
+			// a UTF-8 segment.
+			j := i + size
+			for j < len(text) {
+				r, size := utf8.DecodeRune(text[j:])
+				if r < 0x100 {
+					break
+				}
+				if _, ok := iso8859Runes()[r]; ok && size > 1 {
+					break
+				}
+				j += size
+			}
+			buf = append(buf, ctextESC, '%', 'G')
+			buf = append(buf, bytes.ToValidUTF8(text[i:j], []byte("\uFFFD"))...)
+			buf = append(buf, ctextESC, '%', '@')
+			// Don't rely on designations surviving the segment.
+			gr = 0
+			i = j
+			continue
+		}
+		i += size
+	}
+	return buf
+}
+
+// encodeLatin1 encodes UTF-8 text in ISO 8859-1, the encoding of the STRING
+// target. Characters Latin-1 lacks are replaced with '?'.
+func encodeLatin1(text []byte) []byte {
+	buf := make([]byte, 0, len(text))
+	for _, r := range string(text) {
+		if r > 0xff {
+			r = '?'
+		}
+		buf = append(buf, byte(r))
+	}
+	return buf
+}
+
+// decodeLatin1 decodes ISO 8859-1 text, the encoding of the STRING target,
+// to UTF-8.
+func decodeLatin1(text []byte) []byte {
+	buf := make([]byte, 0, len(text))
+	for _, c := range text {
+		buf = utf8.AppendRune(buf, rune(c))
+	}
+	return buf
+}
+
+// isLatin1 reports whether UTF-8 text only holds characters of ISO 8859-1.
+func isLatin1(text []byte) bool {
+	for _, r := range string(text) {
+		if r > 0xff {
+			return false
+		}
+	}
+	return utf8.Valid(text)
+}
@@ -0,0 +1,99 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDecodeCompoundText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii", "Hello,\tworld\n", "Hello,\tworld\n"},
+		{"latin-1", "Gr\xfc\xdfe", "Grüße"},
+		{"latin-2", "\x1b-B\xb1\xe6\x1b-A\xe6", "ąćæ"},
+		{"cyrillic", "\x1b-L\xbf\xe0\xd8\xd2\xd5\xe2", "Привет"},
+		{"greek", "\x1b-F\xe1\xe2\xe3", "αβγ"},
+		{"jis roman", "\x1b(J\\100~\x1b(B\\", "¥100‾\\"},
+		{"katakana", "\x1b)I\xb1\xb2", "ｱｲ"},
+		{"utf-8 mode", "a\x1b%G€😀\x1b%@b", "a€😀b"},
+		{"utf-8 segment", "a\x1b%/1\x80\x89utf-8\x02€b", "a€b"},
+		{"latin-2 segment", "\x1b%/1\x80\x8ciso8859-2\x02\xb1x", "ąx"},
+		{"unknown segment", "\x1b%/1\x80\x86koi8\x02\xb1", "�"},
+		{"unknown multibyte", "\x1b$(B\x30\x21 \x1b(Bok", "� ok"},
+		{"direction", "\x9b2]abc\x9b]", "abc"},
+		{"controls", "a\rb\x00c\x85d", "abcd"},
+		{"truncated", "ab\x1b$)A\xb0", "ab"},
+		{"truncated escape", "ab\x1b(", "ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decodeCompoundText([]byte(tt.in)); string(got) != tt.want {
+				t.Fatalf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeCompoundText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"ascii", "Hello,\tworld\n", "Hello,\tworld\n"},
+		{"latin-1", "Grüße", "Gr\xfc\xdfe"},
+		{"latin-9", "5 €, 6 ü", "5 \x1b-b\xa4, 6 \x1b-A\xfc"},
+		{"cyrillic", "Привет", "\x1b-L\xbf\xe0\xd8\xd2\xd5\xe2"},
+		{"utf-8", "a😀漢b", "a\x1b%G😀漢\x1b%@b"},
+		{"after utf-8", "😀ü", "\x1b%G😀\x1b%@\x1b-A\xfc"},
+		{"controls", "a\r\nb\x00", "a\nb"},
+		{"invalid", "a\xffb", "a\x1b%G�\x1b%@b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeCompoundText([]byte(tt.in)); string(got) != tt.want {
+				t.Fatalf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLatin1(t *testing.T) {
+	if got := encodeLatin1([]byte("Grüße, €")); string(got) != "Gr\xfc\xdfe, ?" {
+		t.Fatalf("Unexpected Latin-1 %q", got)
+	}
+	if got := decodeLatin1([]byte("Gr\xfc\xdfe")); string(got) != "Grüße" {
+		t.Fatalf("Unexpected UTF-8 %q", got)
+	}
+	if !isLatin1([]byte("Grüße")) || isLatin1([]byte("€")) || isLatin1([]byte("\xff")) {
+		t.Fatal("isLatin1 misclassified text")
+	}
+}
+
+func FuzzCompoundText(f *testing.F) {
+	f.Add([]byte("Grüße, Привет, αβγ, €, 😀"))
+	f.Add([]byte("\x1b%G\xff"))
+
+	f.Fuzz(func(t *testing.T, text []byte) {
+		// Anything decodes to valid UTF-8.
+		if got := decodeCompoundText(text); !utf8.Valid(got) {
+			t.Fatalf("Decoding %q gave invalid UTF-8 %q", text, got)
+		}
+
+		// Valid text without control characters round-trips.
+		if !utf8.Valid(text) || bytes.ContainsFunc(text, func(r rune) bool {
+			return r != '\t' && r != '\n' && (r < 0x20 || r >= 0x7f && r < 0xa0)
+		}) {
+			return
+		}
+		if got := decodeCompoundText(encodeCompoundText(text)); !bytes.Equal(got, text) {
+			t.Fatalf("Round trip of %q gave %q", text, got)
+		}
+	})
+}
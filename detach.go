@@ -0,0 +1,27 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import "sync/atomic"
+
+// detached reports whether writes are served by a detached helper process.
+var detached atomic.Bool
+
+// SetDetached controls whether [Format.Write] and [Restore] hand the data to
+// a detached background process instead of serving it from this one.
+//
+// On X11, the clipboard contents are served by the process that wrote them,
+// so they vanish as soon as a short-lived program exits, unless a clipboard
+// manager takes a copy (see [Flush]). With detaching enabled, writes
+// re-execute the current binary as a background process that owns the
+// clipboard until another application takes over, similar to xclip. The
+// helper is started with a dedicated argument and intercepted during package
+// initialization, before main runs.
+//
+// Detaching only affects the X11 backends (x11 and xproto), the other
+// backends ignore it. On macOS and Windows the system keeps the clipboard
+// contents anyway.
+func SetDetached(enabled bool) {
+	detached.Store(enabled)
+}
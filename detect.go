@@ -0,0 +1,120 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// backendEnv names the environment variable listing the backends to try, in
+// order and separated by commas, such as "x11,osc52". It replaces automatic
+// detection.
+const backendEnv = "NATIVECLIPBOARD_BACKEND"
+
+// backendFactory creates a backend configured with the options. It returns
+// an error wrapping ErrUnavailable if the backend can't be used.
+type backendFactory struct {
+	name string
+	new  func(o *options) (backend, error)
+}
+
+// ignoreOptions adapts a backend constructor that takes no options.
+func ignoreOptions(f func() (backend, error)) func(*options) (backend, error) {
+	return func(*options) (backend, error) {
+		return f()
+	}
+}
+
+// memoryFactory is available on every platform and always works, so it ends
+// the automatic detection chain.
+var memoryFactory = backendFactory{"memory", ignoreOptions(newMemoryBackend)}
+
+// BackendError reports why a backend was rejected.
+type BackendError struct {
+	// Backend is the name of the backend.
+	Backend string
+	// Err is the reason it can't be used.
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return e.Backend + ": " + e.Err.Error()
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// Detection describes how a clipboard picked its backend.
+type Detection struct {
+	// Backend is the name of the backend in use, or empty if none could be
+	// used.
+	Backend string
+	// Rejected lists the backends tried before it, in order, along with the
+	// reason they were rejected.
+	Rejected []*BackendError
+}
+
+// detect tries the backends named by the options or the environment, or
+// the platform's automatic detection chain, and returns the first one that
+// works.
+func detect(o *options) (backend, Detection, error) {
+	chain := autoBackends
+	names := o.backends
+	if len(names) == 0 {
+		names = splitBackends(os.Getenv(backendEnv))
+	}
+	if len(names) > 0 {
+		chain = nil
+		for _, name := range names {
+			chain = append(chain, lookupBackend(name))
+		}
+	} else {
+		chain = append(chain[:len(chain):len(chain)], memoryFactory)
+	}
+
+	var d Detection
+	errs := make([]error, 0, len(chain))
+	for _, f := range chain {
+		b, err := f.new(o)
+		if err == nil {
+			d.Backend = f.name
+			return b, d, nil
+		}
+		berr := &BackendError{Backend: f.name, Err: err}
+		d.Rejected = append(d.Rejected, berr)
+		errs = append(errs, berr)
+	}
+	return nil, d, errors.Join(errs...)
+}
+
+// splitBackends splits a comma separated list of backend names.
+func splitBackends(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// lookupBackend returns the factory of the named backend. Unknown names get
+// a factory that always fails.
+func lookupBackend(name string) backendFactory {
+	if name == memoryFactory.name {
+		return memoryFactory
+	}
+	for _, f := range backends {
+		if f.name == name {
+			return f
+		}
+	}
+	return backendFactory{name, func(*options) (backend, error) {
+		return nil, fmt.Errorf("%w: unknown backend", ErrUnavailable)
+	}}
+}
@@ -0,0 +1,43 @@
+package nativeclipboard
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDetectEnv(t *testing.T) {
+	t.Setenv(backendEnv, " bogus , memory")
+
+	c, err := New()
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	d := c.Detection()
+	if d.Backend != "memory" {
+		t.Fatalf("Expected the memory backend, got %q", d.Backend)
+	}
+	if len(d.Rejected) != 1 || d.Rejected[0].Backend != "bogus" || !errors.Is(d.Rejected[0], ErrUnavailable) {
+		t.Fatalf("Unexpected rejections: %v", d.Rejected)
+	}
+}
+
+func TestWithBackend(t *testing.T) {
+	t.Setenv(backendEnv, "bogus")
+
+	c, err := New(WithBackend("memory"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if d := c.Detection(); d.Backend != "memory" || len(d.Rejected) != 0 {
+		t.Fatalf("Unexpected detection: %+v", d)
+	}
+
+	_, err = New(WithBackend("bogus", "other"))
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable, got %v", err)
+	}
+	var berr *BackendError
+	if !errors.As(err, &berr) || berr.Backend != "bogus" {
+		t.Fatalf("Expected a BackendError for bogus, got %v", err)
+	}
+}
@@ -0,0 +1,753 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeXServer is an in-process X11 server implementing the subset of the
+// core protocol used for selections: atoms, windows, properties, selections
+// and events. It speaks little-endian clients only.
+type fakeXServer struct {
+	ln net.Listener
+
+	mu sync.Mutex
+	// cookie, when set, is the MIT-MAGIC-COOKIE-1 clients must present.
+	cookie     []byte
+	start      time.Time
+	clients    map[*fakeXClient]bool
+	lastClient int
+	atoms      []string
+	atomIDs    map[string]uint32
+	windows    map[uint32]*fakeXWindow
+	selections map[uint32]fakeXSelection
+	// transfers holds the INCR transfers in progress, by requestor window
+	// and property.
+	transfers map[[2]uint32]*fakeXTransfer
+	nextOwner uint32
+
+	// onRequest, when set, is called for every request before it is handled.
+	// Returning false drops the request.
+	onRequest func(c *fakeXClient, opcode byte, body []byte) bool
+}
+
+type fakeXWindow struct {
+	id    uint32
+	owner *fakeXClient
+	props map[uint32]*fakeXProperty
+	masks map[*fakeXClient]uint32
+}
+
+type fakeXProperty struct {
+	typ    uint32
+	format byte
+	data   []byte
+}
+
+type fakeXSelection struct {
+	window uint32
+	owner  *fakeXClient
+	// handler answers conversion requests when the server itself owns the
+	// selection.
+	handler func(r fakeXRequest)
+	time    uint32
+}
+
+// fakeXRequest is a conversion request made to a selection owned by the
+// server.
+type fakeXRequest struct {
+	time, requestor, selection, target, property uint32
+}
+
+// fakeXTransfer is an INCR transfer sent by the server.
+type fakeXTransfer struct {
+	typ   uint32
+	data  []byte
+	chunk int
+}
+
+type fakeXClient struct {
+	srv  *fakeXServer
+	conn net.Conn
+	id   int
+	seq  uint16
+
+	wmu sync.Mutex
+}
+
+const (
+	fakeRoot      = 0x100
+	fakeColormap  = 0x101
+	fakeVisual    = 0x102
+	fakeRIDMask   = 0x1fffff
+	fakeMaxReqLen = 0xffff
+)
+
+var predefinedAtoms = []string{
+	"PRIMARY", "SECONDARY", "ARC", "ATOM", "BITMAP", "CARDINAL", "COLORMAP",
+	"CURSOR", "CUT_BUFFER0", "CUT_BUFFER1", "CUT_BUFFER2", "CUT_BUFFER3",
+	"CUT_BUFFER4", "CUT_BUFFER5", "CUT_BUFFER6", "CUT_BUFFER7", "DRAWABLE",
+	"FONT", "INTEGER", "PIXMAP", "POINT", "RECTANGLE", "RESOURCE_MANAGER",
+	"RGB_COLOR_MAP", "RGB_BEST_MAP", "RGB_BLUE_MAP", "RGB_DEFAULT_MAP",
+	"RGB_GRAY_MAP", "RGB_GREEN_MAP", "RGB_RED_MAP", "STRING", "VISUALID",
+	"WINDOW", "WM_COMMAND", "WM_HINTS", "WM_CLIENT_MACHINE", "WM_ICON_NAME",
+	"WM_ICON_SIZE", "WM_NAME", "WM_NORMAL_HINTS", "WM_SIZE_HINTS",
+	"WM_ZOOM_HINTS", "MIN_SPACE", "NORM_SPACE", "MAX_SPACE", "END_SPACE",
+	"SUPERSCRIPT_X", "SUPERSCRIPT_Y", "SUBSCRIPT_X", "SUBSCRIPT_Y",
+	"UNDERLINE_POSITION", "UNDERLINE_THICKNESS", "STRIKEOUT_ASCENT",
+	"STRIKEOUT_DESCENT", "ITALIC_ANGLE", "X_HEIGHT", "QUAD_WIDTH", "WEIGHT",
+	"POINT_SIZE", "RESOLUTION", "COPYRIGHT", "NOTICE", "FONT_NAME",
+	"FAMILY_NAME", "FULL_NAME", "CAP_HEIGHT", "WM_CLASS", "WM_TRANSIENT_FOR",
+}
+
+func newFakeXServer(ln net.Listener) *fakeXServer {
+	s := &fakeXServer{
+		ln:         ln,
+		start:      time.Now(),
+		clients:    make(map[*fakeXClient]bool),
+		atoms:      append([]string{""}, predefinedAtoms...),
+		atomIDs:    make(map[string]uint32),
+		windows:    make(map[uint32]*fakeXWindow),
+		selections: make(map[uint32]fakeXSelection),
+		transfers:  make(map[[2]uint32]*fakeXTransfer),
+		nextOwner:  0x200,
+	}
+	for i, name := range predefinedAtoms {
+		s.atomIDs[name] = uint32(i + 1)
+	}
+	s.windows[fakeRoot] = &fakeXWindow{
+		id:    fakeRoot,
+		props: make(map[uint32]*fakeXProperty),
+		masks: make(map[*fakeXClient]uint32),
+	}
+	return s
+}
+
+// fakeXDisplays counts the display numbers tried by startFakeX, which start
+// at 100.
+var fakeXDisplays atomic.Int32
+
+// startFakeX starts a fake X server listening on TCP, so both libX11 and the
+// wire protocol backend can reach it, and returns it with its display name.
+func startFakeX(t testing.TB) (*fakeXServer, string) {
+	t.Helper()
+
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("The fake X server only speaks to little-endian clients")
+	}
+
+	// Each server gets a new display, so owners left reconnecting by
+	// earlier tests never reach it.
+	for n := 100 + int(fakeXDisplays.Add(1)); n < 1000; n = 100 + int(fakeXDisplays.Add(1)) {
+		ln, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(6000+n)))
+		if err != nil {
+			continue
+		}
+		s := newFakeXServer(ln)
+		go s.serve()
+		t.Cleanup(s.close)
+		return s, "localhost:" + strconv.Itoa(n)
+	}
+	t.Skip("No free port for the fake X server")
+	return nil, ""
+}
+
+func (s *fakeXServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.lastClient++
+		c := &fakeXClient{srv: s, conn: conn, id: s.lastClient}
+		s.clients[c] = true
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+func (s *fakeXServer) close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// now returns the server time in milliseconds.
+func (s *fakeXServer) now() uint32 {
+	return uint32(time.Since(s.start)/time.Millisecond) + 1
+}
+
+func (c *fakeXClient) serve() {
+	defer c.disconnect()
+
+	if err := c.handshake(); err != nil {
+		return
+	}
+
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+			return
+		}
+		length := int(binary.LittleEndian.Uint16(hdr[2:])) * 4
+		if length < 4 {
+			return
+		}
+		body := make([]byte, length-4)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return
+		}
+
+		c.srv.mu.Lock()
+		c.seq++
+		if c.srv.onRequest == nil || c.srv.onRequest(c, hdr[0], body) {
+			c.handle(hdr[0], hdr[1], body)
+		}
+		c.srv.mu.Unlock()
+	}
+}
+
+func (c *fakeXClient) handshake() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(c.conn, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != 'l' {
+		return errors.New("only little-endian clients are supported")
+	}
+	nameLen := int(binary.LittleEndian.Uint16(hdr[6:]))
+	dataLen := int(binary.LittleEndian.Uint16(hdr[8:]))
+	auth := make([]byte, pad(nameLen)+pad(dataLen))
+	if _, err := io.ReadFull(c.conn, auth); err != nil {
+		return err
+	}
+	name := string(auth[:nameLen])
+	data := auth[pad(nameLen) : pad(nameLen)+dataLen]
+
+	c.srv.mu.Lock()
+	cookie := c.srv.cookie
+	c.srv.mu.Unlock()
+	if cookie != nil && (name != xauthCookie || !bytes.Equal(data, cookie)) {
+		reason := "Authorization required"
+		var b bytes.Buffer
+		b.WriteByte(0)
+		b.WriteByte(byte(len(reason)))
+		binary.Write(&b, binary.LittleEndian, uint16(11))
+		binary.Write(&b, binary.LittleEndian, uint16(0))
+		binary.Write(&b, binary.LittleEndian, uint16(pad(len(reason))/4))
+		b.WriteString(reason)
+		b.Write(make([]byte, pad(len(reason))-len(reason)))
+		c.conn.Write(b.Bytes())
+		return errors.New("authorization failed")
+	}
+
+	vendor := "fake"
+	var v bytes.Buffer
+	le := binary.LittleEndian
+	binary.Write(&v, le, uint32(1))             // release
+	binary.Write(&v, le, uint32(c.id<<21))      // resource id base
+	binary.Write(&v, le, uint32(fakeRIDMask))   // resource id mask
+	binary.Write(&v, le, uint32(0))             // motion buffer size
+	binary.Write(&v, le, uint16(len(vendor)))   // vendor length
+	binary.Write(&v, le, uint16(fakeMaxReqLen)) // maximum request length
+	v.Write([]byte{1, 1, 0, 0, 32, 32, 8, 255, 0, 0, 0, 0})
+	v.WriteString(vendor)
+	v.Write(make([]byte, pad(len(vendor))-len(vendor)))
+	// Pixmap format: depth 24, 32 bits per pixel, scanline pad 32
+	v.Write([]byte{24, 32, 32, 0, 0, 0, 0, 0})
+	// Screen
+	binary.Write(&v, le, uint32(fakeRoot))
+	binary.Write(&v, le, uint32(fakeColormap))
+	binary.Write(&v, le, uint32(0xffffff)) // white pixel
+	binary.Write(&v, le, uint32(0))        // black pixel
+	binary.Write(&v, le, uint32(0))        // current input masks
+	binary.Write(&v, le, []uint16{1024, 768, 270, 203, 1, 1})
+	binary.Write(&v, le, uint32(fakeVisual))
+	v.Write([]byte{0, 0, 24, 1}) // backing stores, save unders, root depth, depths
+	// Depth
+	v.Write([]byte{24, 0})
+	binary.Write(&v, le, uint16(1))
+	v.Write(make([]byte, 4))
+	// Visual
+	binary.Write(&v, le, uint32(fakeVisual))
+	v.Write([]byte{4, 8}) // TrueColor, 8 bits per RGB
+	binary.Write(&v, le, uint16(256))
+	binary.Write(&v, le, []uint32{0xff0000, 0xff00, 0xff, 0})
+
+	var b bytes.Buffer
+	b.Write([]byte{1, 0})
+	binary.Write(&b, le, uint16(11))
+	binary.Write(&b, le, uint16(0))
+	binary.Write(&b, le, uint16(v.Len()/4))
+	b.Write(v.Bytes())
+	_, err := c.conn.Write(b.Bytes())
+	return err
+}
+
+func (c *fakeXClient) disconnect() {
+	c.conn.Close()
+
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+	for id, w := range s.windows {
+		delete(w.masks, c)
+		if w.owner == c {
+			delete(s.windows, id)
+		}
+	}
+	for sel, owner := range s.selections {
+		if owner.owner == c {
+			delete(s.selections, sel)
+		}
+	}
+}
+
+func (c *fakeXClient) write(b []byte) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.Write(b)
+}
+
+func (c *fakeXClient) reply(data byte, body []byte) {
+	extra := 0
+	if len(body) > 24 {
+		extra = pad(len(body)-24) / 4
+	}
+	b := make([]byte, 32+extra*4)
+	b[0] = 1
+	b[1] = data
+	binary.LittleEndian.PutUint16(b[2:], c.seq)
+	binary.LittleEndian.PutUint32(b[4:], uint32(extra))
+	copy(b[8:], body)
+	c.write(b)
+}
+
+func (c *fakeXClient) error(code byte, opcode byte, value uint32) {
+	b := make([]byte, 32)
+	b[1] = code
+	binary.LittleEndian.PutUint16(b[2:], c.seq)
+	binary.LittleEndian.PutUint32(b[4:], value)
+	b[10] = opcode
+	c.write(b)
+}
+
+// event sends a 32-byte event to the client, filling in its sequence number.
+func (c *fakeXClient) event(ev []byte) {
+	b := append([]byte(nil), ev...)
+	binary.LittleEndian.PutUint16(b[2:], c.seq)
+	c.write(b)
+}
+
+const (
+	errBadRequest = 1
+	errBadValue   = 2
+	errBadWindow  = 3
+	errBadAtom    = 5
+	errBadMatch   = 8
+	errBadLength  = 16
+	errBadImpl    = 17
+)
+
+func (c *fakeXClient) handle(opcode, data byte, body []byte) {
+	s := c.srv
+	le := binary.LittleEndian
+	u32 := func(off int) uint32 {
+		if off+4 > len(body) {
+			return 0
+		}
+		return le.Uint32(body[off:])
+	}
+
+	switch opcode {
+	case 1: // CreateWindow
+		wid := u32(0)
+		w := &fakeXWindow{
+			id:    wid,
+			owner: c,
+			props: make(map[uint32]*fakeXProperty),
+			masks: make(map[*fakeXClient]uint32),
+		}
+		mask := u32(24)
+		off := 28
+		for bit := 0; bit < 15; bit++ {
+			if mask&(1<<bit) == 0 {
+				continue
+			}
+			if bit == 11 {
+				w.masks[c] = u32(off)
+			}
+			off += 4
+		}
+		s.windows[wid] = w
+
+	case 2: // ChangeWindowAttributes
+		w := s.windows[u32(0)]
+		if w == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		mask := u32(4)
+		off := 8
+		for bit := 0; bit < 15; bit++ {
+			if mask&(1<<bit) == 0 {
+				continue
+			}
+			if bit == 11 {
+				w.masks[c] = u32(off)
+			}
+			off += 4
+		}
+
+	case 3: // GetWindowAttributes
+		if s.windows[u32(0)] == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		r := make([]byte, 36)
+		le.PutUint32(r[0:], fakeVisual)
+		le.PutUint16(r[4:], 1) // InputOutput
+		c.reply(0, r)
+
+	case 4: // DestroyWindow
+		wid := u32(0)
+		if s.windows[wid] == nil {
+			c.error(errBadWindow, opcode, wid)
+			return
+		}
+		delete(s.windows, wid)
+		for key := range s.transfers {
+			if key[0] == wid {
+				delete(s.transfers, key)
+			}
+		}
+		for sel, owner := range s.selections {
+			if owner.window == wid {
+				delete(s.selections, sel)
+			}
+		}
+
+	case 16: // InternAtom
+		n := int(le.Uint16(body[0:]))
+		name := string(body[4 : 4+n])
+		id := s.atomIDs[name]
+		if data == 0 {
+			id = s.atom(name)
+		}
+		r := make([]byte, 4)
+		le.PutUint32(r, id)
+		c.reply(0, r)
+
+	case 17: // GetAtomName
+		id := u32(0)
+		if id == 0 || int(id) >= len(s.atoms) {
+			c.error(errBadAtom, opcode, id)
+			return
+		}
+		name := s.atoms[id]
+		r := make([]byte, 24+pad(len(name)))
+		le.PutUint16(r[0:], uint16(len(name)))
+		copy(r[24:], name)
+		c.reply(0, r)
+
+	case 18: // ChangeProperty
+		w := s.windows[u32(0)]
+		if w == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		prop, typ := u32(4), u32(8)
+		format := body[12]
+		n := int(u32(16)) * int(format) / 8
+		if 20+n > len(body) {
+			c.error(errBadLength, opcode, 0)
+			return
+		}
+		value := append([]byte(nil), body[20:20+n]...)
+		p := w.props[prop]
+		switch data {
+		case 0: // Replace
+			w.props[prop] = &fakeXProperty{typ: typ, format: format, data: value}
+		case 1, 2: // Prepend, Append
+			if p == nil {
+				w.props[prop] = &fakeXProperty{typ: typ, format: format, data: value}
+			} else if p.typ != typ || p.format != format {
+				c.error(errBadMatch, opcode, 0)
+				return
+			} else if data == 1 {
+				p.data = append(value, p.data...)
+			} else {
+				p.data = append(p.data, value...)
+			}
+		}
+		s.propertyNotify(w, prop, 0)
+
+	case 19: // DeleteProperty
+		w := s.windows[u32(0)]
+		if w == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		if _, ok := w.props[u32(4)]; ok {
+			delete(w.props, u32(4))
+			s.propertyNotify(w, u32(4), 1)
+		}
+
+	case 20: // GetProperty
+		w := s.windows[u32(0)]
+		if w == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		prop, typ := u32(4), u32(8)
+		offset, length := int(u32(12))*4, int(u32(16))*4
+		if length < 0 {
+			length = 1 << 30
+		}
+		r := make([]byte, 24)
+		p := w.props[prop]
+		if p == nil {
+			c.reply(0, r)
+			return
+		}
+		le.PutUint32(r[0:], p.typ)
+		if typ != 0 && typ != p.typ {
+			le.PutUint32(r[4:], uint32(len(p.data)))
+			c.reply(p.format, r)
+			return
+		}
+		t := len(p.data) - offset
+		if t < 0 {
+			c.error(errBadValue, opcode, uint32(offset/4))
+			return
+		}
+		l := min(t, length)
+		after := t - l
+		le.PutUint32(r[4:], uint32(after))
+		le.PutUint32(r[8:], uint32(l/max(int(p.format)/8, 1)))
+		r = append(r, p.data[offset:offset+l]...)
+		c.reply(p.format, r)
+		if data != 0 && after == 0 {
+			delete(w.props, prop)
+			s.propertyNotify(w, prop, 1)
+		}
+
+	case 22: // SetSelectionOwner
+		owner, sel, t := u32(0), u32(4), u32(8)
+		now := s.now()
+		if t == 0 {
+			t = now
+		}
+		prev := s.selections[sel]
+		if t < prev.time || t > now {
+			return
+		}
+		if owner != 0 && s.windows[owner] == nil {
+			c.error(errBadWindow, opcode, owner)
+			return
+		}
+		if prev.owner != nil && prev.window != owner {
+			ev := make([]byte, 32)
+			ev[0] = 29 // SelectionClear
+			le.PutUint32(ev[4:], t)
+			le.PutUint32(ev[8:], prev.window)
+			le.PutUint32(ev[12:], sel)
+			prev.owner.event(ev)
+		}
+		if owner == 0 {
+			s.selections[sel] = fakeXSelection{time: t}
+		} else {
+			s.selections[sel] = fakeXSelection{window: owner, owner: c, time: t}
+		}
+
+	case 23: // GetSelectionOwner
+		r := make([]byte, 4)
+		le.PutUint32(r, s.selections[u32(0)].window)
+		c.reply(0, r)
+
+	case 24: // ConvertSelection
+		requestor, sel, target, prop, t := u32(0), u32(4), u32(8), u32(12), u32(16)
+		if s.windows[requestor] == nil {
+			c.error(errBadWindow, opcode, requestor)
+			return
+		}
+		ev := make([]byte, 32)
+		if owner := s.selections[sel]; owner.owner != nil {
+			ev[0] = 30 // SelectionRequest
+			le.PutUint32(ev[4:], t)
+			le.PutUint32(ev[8:], owner.window)
+			le.PutUint32(ev[12:], requestor)
+			le.PutUint32(ev[16:], sel)
+			le.PutUint32(ev[20:], target)
+			le.PutUint32(ev[24:], prop)
+			owner.owner.event(ev)
+			return
+		} else if owner.handler != nil {
+			owner.handler(fakeXRequest{time: t, requestor: requestor, selection: sel, target: target, property: prop})
+			return
+		}
+		ev[0] = 31 // SelectionNotify
+		le.PutUint32(ev[4:], t)
+		le.PutUint32(ev[8:], requestor)
+		le.PutUint32(ev[12:], sel)
+		le.PutUint32(ev[16:], target)
+		c.event(ev)
+
+	case 25: // SendEvent
+		w := s.windows[u32(0)]
+		if w == nil {
+			c.error(errBadWindow, opcode, u32(0))
+			return
+		}
+		ev := append([]byte(nil), body[8:40]...)
+		ev[0] |= 0x80
+		mask := u32(4)
+		if mask == 0 {
+			if w.owner != nil {
+				w.owner.event(ev)
+			}
+			return
+		}
+		for client, m := range w.masks {
+			if m&mask != 0 {
+				client.event(ev)
+			}
+		}
+
+	case 43: // GetInputFocus
+		r := make([]byte, 4)
+		le.PutUint32(r, fakeRoot)
+		c.reply(1, r)
+
+	case 98: // QueryExtension
+		c.reply(0, make([]byte, 4))
+
+	case 8, 10, 55, 56, 60, 127: // MapWindow, UnmapWindow, CreateGC, ChangeGC, FreeGC, NoOperation
+
+	default:
+		c.error(errBadImpl, opcode, 0)
+	}
+}
+
+// propertyNotify sends a PropertyNotify event to every client that selected
+// PropertyChangeMask on w.
+func (s *fakeXServer) propertyNotify(w *fakeXWindow, prop uint32, state byte) {
+	ev := make([]byte, 32)
+	ev[0] = 28
+	binary.LittleEndian.PutUint32(ev[4:], w.id)
+	binary.LittleEndian.PutUint32(ev[8:], prop)
+	binary.LittleEndian.PutUint32(ev[12:], s.now())
+	ev[16] = state
+	for client, mask := range w.masks {
+		if mask&PropertyChangeMask != 0 {
+			client.event(ev)
+		}
+	}
+
+	// The requestor deleting the property asks for the next chunk of an
+	// INCR transfer.
+	key := [2]uint32{w.id, prop}
+	if tr := s.transfers[key]; tr != nil && state == 1 {
+		n := min(tr.chunk, len(tr.data))
+		chunk := tr.data[:n]
+		tr.data = tr.data[n:]
+		if n == 0 {
+			delete(s.transfers, key)
+		}
+		s.setProperty(w.id, prop, tr.typ, 8, chunk)
+	}
+}
+
+// own makes the server own the selection, answering conversion requests with
+// handler. The handler runs with the server locked, and uses setProperty,
+// notify and sendIncr to answer, or nothing to simulate an owner that never
+// replies.
+func (s *fakeXServer) own(selection string, handler func(r fakeXRequest)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sel := s.atom(selection)
+	prev := s.selections[sel]
+	if prev.handler != nil {
+		delete(s.windows, prev.window)
+	}
+	if prev.owner != nil {
+		ev := make([]byte, 32)
+		ev[0] = 29 // SelectionClear
+		binary.LittleEndian.PutUint32(ev[4:], s.now())
+		binary.LittleEndian.PutUint32(ev[8:], prev.window)
+		binary.LittleEndian.PutUint32(ev[12:], sel)
+		prev.owner.event(ev)
+	}
+
+	s.nextOwner++
+	s.windows[s.nextOwner] = &fakeXWindow{
+		id:    s.nextOwner,
+		props: make(map[uint32]*fakeXProperty),
+		masks: make(map[*fakeXClient]uint32),
+	}
+	s.selections[sel] = fakeXSelection{window: s.nextOwner, handler: handler, time: s.now()}
+}
+
+// atom returns the atom named name, interning it if needed.
+func (s *fakeXServer) atom(name string) uint32 {
+	id, ok := s.atomIDs[name]
+	if !ok {
+		s.atoms = append(s.atoms, name)
+		id = uint32(len(s.atoms) - 1)
+		s.atomIDs[name] = id
+	}
+	return id
+}
+
+// setProperty replaces a window property, if the window still exists.
+func (s *fakeXServer) setProperty(window, prop, typ uint32, format byte, data []byte) {
+	w := s.windows[window]
+	if w == nil {
+		return
+	}
+	w.props[prop] = &fakeXProperty{typ: typ, format: format, data: data}
+	s.propertyNotify(w, prop, 0)
+}
+
+// notify sends the SelectionNotify event answering r, with property None if
+// the conversion was refused.
+func (s *fakeXServer) notify(r fakeXRequest, property uint32) {
+	w := s.windows[r.requestor]
+	if w == nil || w.owner == nil {
+		return
+	}
+	ev := make([]byte, 32)
+	ev[0] = 31 // SelectionNotify
+	binary.LittleEndian.PutUint32(ev[4:], r.time)
+	binary.LittleEndian.PutUint32(ev[8:], r.requestor)
+	binary.LittleEndian.PutUint32(ev[12:], r.selection)
+	binary.LittleEndian.PutUint32(ev[16:], r.target)
+	binary.LittleEndian.PutUint32(ev[20:], property)
+	w.owner.event(ev)
+}
+
+// sendIncr answers r with data of type typ, sent with the INCR mechanism in
+// chunks of the given size.
+func (s *fakeXServer) sendIncr(r fakeXRequest, typ uint32, data []byte, chunk int) {
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(data)))
+	s.setProperty(r.requestor, r.property, s.atom("INCR"), 32, size)
+	s.transfers[[2]uint32{r.requestor, r.property}] = &fakeXTransfer{typ: typ, data: data, chunk: chunk}
+	s.notify(r, r.property)
+}
@@ -0,0 +1,179 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"context"
+	"fmt"
+)
+
+// Clipboard is a connection to a clipboard backend. The package level
+// functions and the [Format] and [Selection] methods use a default
+// Clipboard, which picks its backend automatically.
+//
+// Use [New] to get a Clipboard configured with options, such as a specific
+// backend.
+type Clipboard struct {
+	b         backend
+	err       error
+	detection Detection
+	text      *TextOptions
+}
+
+// New returns a Clipboard using the first backend that works, see
+// [Detection]. It returns an error if none does.
+func New(opts ...Option) (*Clipboard, error) {
+	c := newClipboard(opts...)
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// newClipboard is like New, but keeps the initialization error in the
+// returned Clipboard.
+func newClipboard(opts ...Option) *Clipboard {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	c := &Clipboard{text: o.text}
+	c.b, c.detection, c.err = detect(&o)
+	return c
+}
+
+// Detection reports which backend the clipboard uses and why the backends
+// tried before it were rejected.
+func (c *Clipboard) Detection() Detection {
+	return c.detection
+}
+
+// check returns the error to report for operations on the selection, if
+// any.
+func (c *Clipboard) check(s Selection) error {
+	if c.err != nil {
+		return c.err
+	}
+	if !c.b.supports(s) {
+		return fmt.Errorf("%w: %s selection is not available with the %s backend", ErrUnsupported, s, c.detection.Backend)
+	}
+	return nil
+}
+
+// Read reads the selection data in format f. See [Format.Read].
+func (c *Clipboard) Read(s Selection, f Format) ([]byte, error) {
+	if err := c.check(s); err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	buf, err := c.b.read(s, f)
+	if err != nil {
+		return nil, err
+	}
+	return c.normalize(f, buf)
+}
+
+// Write writes data in format f to the selection. See [Format.Write].
+func (c *Clipboard) Write(s Selection, f Format, buf []byte) (<-chan struct{}, error) {
+	if err := c.check(s); err != nil {
+		return nil, err
+	}
+	buf, err := c.normalize(f, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	changed, err := c.b.write(s, f, buf)
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// Watch returns a channel that receives the selection data in format f
+// whenever it changes. See [Format.Watch].
+func (c *Clipboard) Watch(ctx context.Context, s Selection, f Format) (<-chan []byte, error) {
+	if err := c.check(s); err != nil {
+		return nil, err
+	}
+	ch, err := c.b.watch(ctx, s, f)
+	if err != nil {
+		return nil, err
+	}
+	return c.normalizeWatch(ctx, f, ch), nil
+}
+
+// ReadAny reads the first of the given formats that the selection currently
+// offers. See [ReadAny].
+func (c *Clipboard) ReadAny(s Selection, formats ...Format) (Format, []byte, error) {
+	f, e, err := c.ReadAnyEntry(s, formats...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return f, e.Data, nil
+}
+
+// ReadAnyEntry is like [Clipboard.ReadAny] but also reports the native
+// target the data was read from. See [ReadAnyEntry].
+func (c *Clipboard) ReadAnyEntry(s Selection, formats ...Format) (Format, Entry, error) {
+	if err := c.check(s); err != nil {
+		return 0, Entry{}, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	f, e, err := c.b.readAny(s, formats)
+	if err != nil {
+		return 0, Entry{}, err
+	}
+	if e.Data, err = c.normalize(f, e.Data); err != nil {
+		return 0, Entry{}, err
+	}
+	return f, e, nil
+}
+
+// Snapshot captures every representation currently offered by the
+// selection. See [Snapshot].
+func (c *Clipboard) Snapshot(s Selection) (*Contents, error) {
+	if err := c.check(s); err != nil {
+		return nil, err
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	return c.b.snapshot(s)
+}
+
+// Restore replaces the selection contents with all representations held by
+// contents. See [Restore].
+func (c *Clipboard) Restore(s Selection, contents *Contents) (<-chan struct{}, error) {
+	if err := c.check(s); err != nil {
+		return nil, err
+	}
+	if contents == nil {
+		contents = &Contents{}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	return c.b.restore(s, contents)
+}
+
+// Flush makes sure the clipboard contents written by this process outlive
+// it, and reports whether they will. See [Flush].
+func (c *Clipboard) Flush(ctx context.Context) (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	}
+	return c.b.flush(ctx)
+}
@@ -0,0 +1,70 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+// Option configures a [Clipboard].
+type Option func(*options)
+
+type options struct {
+	// backends lists the backends to try, in order. Automatic detection is
+	// used if it's empty.
+	backends []string
+	// display is the X display to connect to, $DISPLAY if empty.
+	display string
+	// xauthority is the X authority file, $XAUTHORITY or ~/.Xauthority if
+	// empty.
+	xauthority string
+	// libX11 lists paths to load libX11 from, before the default ones.
+	libX11 []string
+	// text normalizes text, the options set with SetTextOptions if nil.
+	text *TextOptions
+}
+
+// WithBackend makes the clipboard try the named backends, in order, instead
+// of detecting one automatically. It takes precedence over the
+// NATIVECLIPBOARD_BACKEND environment variable.
+//
+// The names are "wayland", "x11", "xproto", "command", "tmux", "terminal",
+// "kitty" and "osc52" on Linux and BSD, "macos" on macOS, "windows" on Windows, and
+// "memory" everywhere.
+func WithBackend(names ...string) Option {
+	return func(o *options) {
+		o.backends = append(o.backends, names...)
+	}
+}
+
+// WithDisplay sets the X display to connect to, such as ":99", instead of
+// $DISPLAY. Automatic detection skips Wayland when it is set. It has no
+// effect on other platforms.
+func WithDisplay(name string) Option {
+	return func(o *options) {
+		o.display = name
+	}
+}
+
+// WithXAuthority sets the X authority file holding the cookie to connect to
+// the X display with, instead of $XAUTHORITY or ~/.Xauthority. It has no
+// effect on other platforms.
+func WithXAuthority(path string) Option {
+	return func(o *options) {
+		o.xauthority = path
+	}
+}
+
+// WithLibX11 adds paths to load libX11 from, tried before the default
+// ones. libX11 is loaded once per process, so the paths only matter to the
+// first clipboard that loads it. It has no effect on other platforms.
+func WithLibX11(paths ...string) Option {
+	return func(o *options) {
+		o.libX11 = append(o.libX11, paths...)
+	}
+}
+
+// WithTextOptions sets how the clipboard normalizes text, instead of the
+// options set with [SetTextOptions].
+func WithTextOptions(text TextOptions) Option {
+	return func(o *options) {
+		o.text = &text
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"context"
+	"fmt"
+)
+
+// Selection identifies a system selection. Besides the regular clipboard,
+// X11 and Wayland have a primary selection that holds the most recently
+// selected text and is pasted with the middle mouse button.
+//
+// The [Format] methods and the package level functions operate on
+// [ClipboardSelection]. Use the Selection methods to reach the primary
+// selection through the same API.
+type Selection int
+
+// Supported selections
+const (
+	// ClipboardSelection is the regular clipboard, used by copy and paste.
+	ClipboardSelection Selection = iota
+	// PrimarySelection is the primary selection. It only exists on X11 and
+	// Wayland, operations on other platforms return ErrUnsupported.
+	PrimarySelection
+)
+
+// String returns the name of the selection.
+func (s Selection) String() string {
+	switch s {
+	case ClipboardSelection:
+		return "clipboard"
+	case PrimarySelection:
+		return "primary"
+	default:
+		return fmt.Sprintf("Selection(%d)", int(s))
+	}
+}
+
+// Read reads the selection data in format f.
+// Returns an error if the selection is unavailable or initialization failed.
+func (s Selection) Read(f Format) ([]byte, error) {
+	return defaultClipboard().Read(s, f)
+}
+
+// Write writes data in format f to the selection.
+// Returns a channel that receives a signal when the selection content
+// has been overwritten by another application, and an error if the operation fails.
+func (s Selection) Write(f Format, buf []byte) (<-chan struct{}, error) {
+	return defaultClipboard().Write(s, f, buf)
+}
+
+// Watch returns a channel that receives the selection data in format f
+// whenever it changes. See [Format.Watch].
+func (s Selection) Watch(ctx context.Context, f Format) (<-chan []byte, error) {
+	return defaultClipboard().Watch(ctx, s, f)
+}
+
+// ReadAny reads the first of the given formats that the selection currently
+// offers. See [ReadAny].
+func (s Selection) ReadAny(formats ...Format) (Format, []byte, error) {
+	return defaultClipboard().ReadAny(s, formats...)
+}
+
+// ReadAnyEntry is like [Selection.ReadAny] but also reports the native
+// target the data was read from. See [ReadAnyEntry].
+func (s Selection) ReadAnyEntry(formats ...Format) (Format, Entry, error) {
+	return defaultClipboard().ReadAnyEntry(s, formats...)
+}
+
+// Snapshot captures every representation currently offered by the
+// selection. See [Snapshot].
+func (s Selection) Snapshot() (*Contents, error) {
+	return defaultClipboard().Snapshot(s)
+}
+
+// Restore replaces the selection contents with all representations held by
+// c. See [Restore].
+func (s Selection) Restore(c *Contents) (<-chan struct{}, error) {
+	return defaultClipboard().Restore(s, c)
+}
@@ -0,0 +1,230 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidArchive indicates that the data given to [Contents.UnmarshalBinary]
+// is not a valid clipboard archive.
+var ErrInvalidArchive = errors.New("invalid clipboard archive")
+
+// Contents holds every representation the clipboard offered at the time it
+// was captured with [Snapshot].
+type Contents struct {
+	// Entries lists the captured representations in the order the clipboard
+	// owner offered them.
+	Entries []Entry
+}
+
+// Entry is a single clipboard representation.
+type Entry struct {
+	// Target is the native name of the representation. This is the atom name
+	// on X11 (e.g. "UTF8_STRING"), the MIME type on Wayland (e.g.
+	// "text/plain;charset=utf-8"), the pasteboard type on macOS (e.g.
+	// "public.utf8-plain-text") and the clipboard format name on Windows
+	// (e.g. "CF_UNICODETEXT").
+	Target string
+	// Type is the type of the data on X11 (e.g. "COMPOUND_TEXT" for the
+	// "TEXT" target). Empty means the type is Target.
+	Type string
+	// Format is the X11 property format of the data, 8, 16 or 32. Items of
+	// format 16 and 32 are held in native byte order. Zero means 8.
+	Format int
+	// Data is the raw data of the representation.
+	Data []byte
+}
+
+// entryFormat returns the X11 property format of e.
+func entryFormat(e Entry) int {
+	if e.Format == 0 {
+		return 8
+	}
+	return e.Format
+}
+
+// textTargets are the targets holding text across platforms.
+var textTargets = map[string]bool{
+	"text/plain;charset=utf-8": true,
+	"text/plain":               true,
+	"UTF8_STRING":              true,
+	"STRING":                   true,
+	"TEXT":                     true,
+}
+
+// Snapshot captures every representation currently offered by the clipboard.
+// The returned contents can be put back later with [Restore], or saved to
+// disk using [Contents.MarshalBinary].
+//
+// Representations that cannot be copied as plain bytes, such as GDI handles
+// on Windows or atom lists on X11, are skipped.
+func Snapshot() (*Contents, error) {
+	return ClipboardSelection.Snapshot()
+}
+
+// Restore replaces the clipboard contents with all representations held by c.
+// Like [Format.Write], it returns a channel that receives a signal when the
+// restored contents have been overwritten by another application.
+func Restore(c *Contents) (<-chan struct{}, error) {
+	return ClipboardSelection.Restore(c)
+}
+
+// archiveMagic identifies a clipboard archive produced by [Contents.MarshalBinary].
+var archiveMagic = [4]byte{'N', 'C', 'B', 'A'}
+
+// archiveVersion is the version of the archive encoding. Version 1 archives,
+// which don't record the type and format of entries, are still decoded.
+const archiveVersion = 2
+
+// MarshalBinary encodes the contents into a self-contained archive that can
+// be written to disk and decoded later with [Contents.UnmarshalBinary].
+//
+// The archive uses big-endian integers and has the following layout:
+//
+//	magic    [4]byte  "NCBA"
+//	version  uint8    2
+//	count    uint32   number of entries
+//
+// followed by count entries, each encoded as:
+//
+//	nameLen  uint16   length of the target name
+//	name     [nameLen]byte
+//	typeLen  uint16   length of the type name
+//	type     [typeLen]byte
+//	format   uint8    8, 16 or 32
+//	dataLen  uint64   length of the data
+//	data     [dataLen]byte
+//
+// Items of format 16 and 32 are stored big-endian.
+func (c *Contents) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(archiveMagic[:])
+	buf.WriteByte(archiveVersion)
+	binary.Write(&buf, binary.BigEndian, uint32(len(c.Entries)))
+	for _, e := range c.Entries {
+		if len(e.Target) > 0xffff {
+			return nil, fmt.Errorf("target name too long: %d bytes", len(e.Target))
+		}
+		if len(e.Type) > 0xffff {
+			return nil, fmt.Errorf("type name too long: %d bytes", len(e.Type))
+		}
+		format := entryFormat(e)
+		if format != 8 && format != 16 && format != 32 {
+			return nil, fmt.Errorf("invalid format %d", e.Format)
+		}
+		if len(e.Data)%(format/8) != 0 {
+			return nil, fmt.Errorf("data of format %d has a partial item", format)
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(len(e.Target)))
+		buf.WriteString(e.Target)
+		binary.Write(&buf, binary.BigEndian, uint16(len(e.Type)))
+		buf.WriteString(e.Type)
+		buf.WriteByte(byte(format))
+		binary.Write(&buf, binary.BigEndian, uint64(len(e.Data)))
+		buf.Write(swapItems(e.Data, format, binary.NativeEndian, binary.BigEndian))
+	}
+	return buf.Bytes(), nil
+}
+
+// swapItems returns data of the given format with its items converted from
+// one byte order to another.
+func swapItems(data []byte, format int, from, to binary.ByteOrder) []byte {
+	if format == 8 {
+		return data
+	}
+	out := make([]byte, len(data))
+	for i := 0; i+format/8 <= len(data); i += format / 8 {
+		if format == 16 {
+			to.PutUint16(out[i:], from.Uint16(data[i:]))
+		} else {
+			to.PutUint32(out[i:], from.Uint32(data[i:]))
+		}
+	}
+	return out
+}
+
+// UnmarshalBinary decodes an archive produced by [Contents.MarshalBinary],
+// replacing any entries c already holds.
+func (c *Contents) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+
+	var magic [4]byte
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != archiveMagic {
+		return ErrInvalidArchive
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return ErrInvalidArchive
+	}
+	if version != 1 && version != archiveVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidArchive, version)
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return ErrInvalidArchive
+	}
+
+	var entries []Entry
+	for i := uint32(0); i < count; i++ {
+		var nameLen uint16
+		if err := binary.Read(r, binary.BigEndian, &nameLen); err != nil {
+			return ErrInvalidArchive
+		}
+		name := make([]byte, nameLen)
+		if _, err := io.ReadFull(r, name); err != nil {
+			return ErrInvalidArchive
+		}
+
+		e := Entry{Target: string(name), Format: 8}
+		if version >= 2 {
+			var typeLen uint16
+			if err := binary.Read(r, binary.BigEndian, &typeLen); err != nil {
+				return ErrInvalidArchive
+			}
+			typ := make([]byte, typeLen)
+			if _, err := io.ReadFull(r, typ); err != nil {
+				return ErrInvalidArchive
+			}
+			format, err := r.ReadByte()
+			if err != nil {
+				return ErrInvalidArchive
+			}
+			if format != 8 && format != 16 && format != 32 {
+				return fmt.Errorf("%w: invalid format %d", ErrInvalidArchive, format)
+			}
+			e.Type, e.Format = string(typ), int(format)
+		}
+
+		var dataLen uint64
+		if err := binary.Read(r, binary.BigEndian, &dataLen); err != nil {
+			return ErrInvalidArchive
+		}
+		if dataLen > uint64(r.Len()) {
+			return ErrInvalidArchive
+		}
+		buf := make([]byte, dataLen)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return ErrInvalidArchive
+		}
+
+		if len(buf)%(e.Format/8) != 0 {
+			return ErrInvalidArchive
+		}
+		e.Data = swapItems(buf, e.Format, binary.BigEndian, binary.NativeEndian)
+
+		entries = append(entries, e)
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: trailing data", ErrInvalidArchive)
+	}
+
+	c.Entries = entries
+	return nil
+}
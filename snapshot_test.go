@@ -0,0 +1,130 @@
+package nativeclipboard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestContentsArchive(t *testing.T) {
+	c := &Contents{Entries: []Entry{
+		{Target: "UTF8_STRING", Data: []byte("hello")},
+		{Target: "image/png", Data: []byte{0x89, 'P', 'N', 'G'}},
+		{Target: "application/x-empty", Data: []byte{}},
+		{Target: "TEXT", Type: "COMPOUND_TEXT", Format: 8, Data: []byte("\x1b-L\xbf")},
+		{Target: "application/x-counts", Type: "INTEGER", Format: 32, Data: binary.NativeEndian.AppendUint32(nil, 1)},
+	}}
+
+	data, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	var got Contents
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	if len(got.Entries) != len(c.Entries) {
+		t.Fatalf("Expected %d entries, got %d", len(c.Entries), len(got.Entries))
+	}
+	for i, e := range c.Entries {
+		if got.Entries[i].Target != e.Target {
+			t.Errorf("Entry %d: expected target %q, got %q", i, e.Target, got.Entries[i].Target)
+		}
+		if got.Entries[i].Type != e.Type || entryFormat(got.Entries[i]) != entryFormat(e) {
+			t.Errorf("Entry %d: expected type %q of format %d, got %q of format %d", i, e.Type, entryFormat(e), got.Entries[i].Type, got.Entries[i].Format)
+		}
+		if !bytes.Equal(got.Entries[i].Data, e.Data) {
+			t.Errorf("Entry %d: expected data %q, got %q", i, e.Data, got.Entries[i].Data)
+		}
+	}
+
+	// Items of format 32 are stored big-endian.
+	if !bytes.HasSuffix(data, []byte{0, 0, 0, 1}) {
+		t.Errorf("Expected big-endian items, got %q", data)
+	}
+}
+
+func TestContentsArchiveVersion1(t *testing.T) {
+	data := []byte("NCBA\x01\x00\x00\x00\x01\x00\x0bUTF8_STRING\x00\x00\x00\x00\x00\x00\x00\x05hello")
+
+	var c Contents
+	if err := c.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+	if len(c.Entries) != 1 || c.Entries[0].Target != "UTF8_STRING" || c.Entries[0].Type != "" || string(c.Entries[0].Data) != "hello" {
+		t.Fatalf("Unexpected entries: %+v", c.Entries)
+	}
+}
+
+func TestContentsArchiveInvalid(t *testing.T) {
+	valid, err := (&Contents{Entries: []Entry{{Target: "UTF8_STRING", Data: []byte("hello")}}}).MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"empty":     nil,
+		"bad magic": append([]byte("XXXX"), valid[4:]...),
+		"version":   append(append([]byte{}, valid[:4]...), append([]byte{99}, valid[5:]...)...),
+		"truncated": valid[:len(valid)-1],
+		"trailing":  append(append([]byte{}, valid...), 0),
+		"format":    bytes.Replace(valid, []byte("UTF8_STRING\x00\x00\x08"), []byte("UTF8_STRING\x00\x00\x07"), 1),
+	}
+	for name, data := range tests {
+		var c Contents
+		if err := c.UnmarshalBinary(data); !errors.Is(err, ErrInvalidArchive) {
+			t.Errorf("%s: expected ErrInvalidArchive, got %v", name, err)
+		}
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	testData := []byte("Snapshot test")
+	if _, err := Text.Write(testData); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	snap, err := Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snap.Entries) == 0 {
+		t.Fatal("Snapshot returned no entries")
+	}
+
+	// Round-trip through the archive encoding like a saved snapshot would
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary failed: %v", err)
+	}
+	var loaded Contents
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary failed: %v", err)
+	}
+
+	if _, err := Text.Write([]byte("Overwritten")); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := Restore(&loaded); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	got, err := Text.Read()
+	if err != nil {
+		t.Fatalf("Text.Read failed: %v", err)
+	}
+	if string(got) != string(testData) {
+		t.Fatalf("Expected %q, got %q", testData, got)
+	}
+}
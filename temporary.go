@@ -0,0 +1,119 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// changeCounter is implemented by backends that can tell right away whether
+// a selection changed, rather than by polling. The selections of other
+// backends are read back to find out.
+type changeCounter interface {
+	// changeCount returns a value that changes whenever the contents of
+	// the selection are replaced. It returns an error wrapping
+	// ErrUnsupported if the backend can't detect changes at all.
+	changeCount(sel Selection) (uint64, error)
+}
+
+// WithTemporary places buf on the clipboard in format f for the duration of
+// fn and then puts back whatever the clipboard held before.
+//
+// The previous contents are captured with [Snapshot] before buf is written.
+// Once fn returns, they are restored with [Restore], unless another
+// application changed the clipboard in the meantime, in which case the newer
+// contents are left untouched. The terminal backends (OSC 52 and kitty) can't
+// detect such changes, so they always restore.
+//
+// If ctx is done before fn returns, the previous contents are restored right
+// away and ctx.Err() is returned; fn keeps running in its own goroutine
+// until it returns, so it should honor the same context. Otherwise the error
+// returned by fn is returned, joined with any error encountered while
+// restoring.
+func WithTemporary(ctx context.Context, f Format, buf []byte, fn func() error) error {
+	return defaultClipboard().WithTemporary(ctx, ClipboardSelection, f, buf, fn)
+}
+
+// WithTemporary places buf on the selection in format f for the duration of
+// fn and then puts back whatever the selection held before. See
+// [WithTemporary].
+func (c *Clipboard) WithTemporary(ctx context.Context, s Selection, f Format, buf []byte, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	saved, err := c.Snapshot(s)
+	if err != nil {
+		return err
+	}
+
+	changed, err := c.Write(s, f, buf)
+	if err != nil {
+		return err
+	}
+	replaced := c.replacedCheck(s, f, buf)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// The changed channel may only fire a while after someone else took
+	// the clipboard, if at all, so check again right before restoring.
+	if replaced() {
+		return err
+	}
+	select {
+	case <-changed:
+		// Someone else owns the clipboard now, leave their contents alone.
+		return err
+	default:
+	}
+
+	if _, rerr := c.Restore(s, saved); rerr != nil {
+		return errors.Join(err, rerr)
+	}
+	return err
+}
+
+// replacedCheck returns a function reporting whether the selection was
+// replaced since buf was written to it in format f. The change counts of the
+// backend are compared, or the selection is read back for backends that
+// don't count changes. It always reports false if the backend can't tell.
+func (c *Clipboard) replacedCheck(s Selection, f Format, buf []byte) func() bool {
+	cc, ok := c.b.(changeCounter)
+	if !ok {
+		// Write already normalized buf successfully.
+		buf, _ = c.normalize(f, buf)
+		return func() bool {
+			data, err := c.Read(s, f)
+			return err != nil || !bytes.Equal(data, buf)
+		}
+	}
+
+	count, err := c.changeCount(cc, s)
+	if err != nil {
+		return func() bool { return false }
+	}
+	return func() bool {
+		now, err := c.changeCount(cc, s)
+		return err != nil || now != count
+	}
+}
+
+// changeCount returns the change count of the selection.
+func (c *Clipboard) changeCount(cc changeCounter, s Selection) (uint64, error) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	return cc.changeCount(s)
+}
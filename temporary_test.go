@@ -0,0 +1,76 @@
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithTemporary(t *testing.T) {
+	original := []byte("Original content")
+	if _, err := Text.Write(original); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	temporary := []byte("Temporary content")
+	err := WithTemporary(context.Background(), Text, temporary, func() error {
+		time.Sleep(100 * time.Millisecond)
+
+		data, err := Text.Read()
+		if err != nil {
+			return err
+		}
+		if string(data) != string(temporary) {
+			t.Errorf("Expected %q during callback, got %q", temporary, data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithTemporary failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	data, err := Text.Read()
+	if err != nil {
+		t.Fatalf("Text.Read failed: %v", err)
+	}
+	if string(data) != string(original) {
+		t.Fatalf("Expected %q after restore, got %q", original, data)
+	}
+}
+
+func TestWithTemporaryChanged(t *testing.T) {
+	if _, err := Text.Write([]byte("Original content")); err != nil {
+		t.Fatalf("Text.Write failed: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Another writer replacing the temporary content must win
+	newer := []byte("Newer content")
+	errCallback := errors.New("callback failed")
+	err := WithTemporary(context.Background(), Text, []byte("Temporary content"), func() error {
+		time.Sleep(100 * time.Millisecond)
+		if _, err := Text.Write(newer); err != nil {
+			return err
+		}
+		return errCallback
+	})
+	if !errors.Is(err, errCallback) {
+		t.Fatalf("Expected callback error, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	data, err := Text.Read()
+	if err != nil {
+		t.Fatalf("Text.Read failed: %v", err)
+	}
+	if string(data) != string(newer) {
+		t.Fatalf("Expected %q to be kept, got %q", newer, data)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// terminal is the terminal the terminal backends talk to with escape
+// sequences.
+type terminal struct {
+	// path is the terminal device, usually /dev/tty, the controlling
+	// terminal of the process.
+	path string
+	// mux is the terminal multiplexer running between us and the terminal
+	// emulator, if any.
+	mux multiplexer
+}
+
+// ttyPath is the controlling terminal of the process.
+const ttyPath = "/dev/tty"
+
+// multiplexer is a terminal multiplexer. Multiplexers interpret the escape
+// sequences written to them, so sequences meant for the terminal emulator
+// must be wrapped in a DCS passthrough string.
+type multiplexer int
+
+const (
+	noMultiplexer multiplexer = iota
+	tmuxMultiplexer
+	screenMultiplexer
+)
+
+// screenChunkSize bounds the DCS strings sent to screen, which drops longer
+// strings.
+const screenChunkSize = 512
+
+// newTerminal returns the controlling terminal, detecting tmux and screen
+// from the environment.
+func newTerminal() terminal {
+	t := terminal{path: ttyPath}
+	switch {
+	case os.Getenv("TMUX") != "":
+		t.mux = tmuxMultiplexer
+	case os.Getenv("STY") != "":
+		t.mux = screenMultiplexer
+	}
+	return t
+}
+
+// passthrough wraps seq so it reaches the terminal emulator through the
+// multiplexer. tmux needs escape characters doubled, and only forwards the
+// sequence when its allow-passthrough option is on. screen ends DCS
+// strings at the first ST, so sequences are terminated with BEL instead and
+// sent in chunks.
+func (t terminal) passthrough(seq string) string {
+	switch t.mux {
+	case tmuxMultiplexer:
+		return "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	case screenMultiplexer:
+		seq = strings.ReplaceAll(seq, "\x1b\\", "\a")
+		var b strings.Builder
+		for len(seq) > 0 {
+			n := min(len(seq), screenChunkSize)
+			b.WriteString("\x1bP" + seq[:n] + "\x1b\\")
+			seq = seq[n:]
+		}
+		return b.String()
+	}
+	return seq
+}
+
+// tmuxPassthrough reports whether tmux forwards DCS passthrough strings to
+// the terminal emulator. tmux before 3.3 lacks the allow-passthrough option
+// and always does.
+func tmuxPassthrough() bool {
+	out, err := exec.Command("tmux", "display-message", "-p", "#{allow-passthrough}").Output()
+	if err != nil {
+		return false
+	}
+	switch strings.TrimSpace(string(out)) {
+	case "on", "all", "":
+		return true
+	}
+	return false
+}
+
+// open opens the terminal device.
+func (t terminal) open() (*os.File, error) {
+	f, err := os.OpenFile(t.path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return f, nil
+}
+
+// write writes an escape sequence to the terminal.
+func (t terminal) write(seq string) error {
+	f, err := t.open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(t.passthrough(seq))
+	return err
+}
+
+// query writes an escape sequence to the terminal and reads the reply until
+// complete reports it's whole. It returns ErrUnavailable if the terminal
+// doesn't reply within timeout.
+func (t terminal) query(seq string, timeout time.Duration, complete func([]byte) bool) ([]byte, error) {
+	return t.exchange(t.passthrough(seq), timeout, complete)
+}
+
+// exchange is like query, but writes seq as is. The terminal is put in raw
+// mode meanwhile so the reply is neither echoed nor line buffered.
+func (t terminal) exchange(seq string, timeout time.Duration, complete func([]byte) bool) ([]byte, error) {
+	f, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	restore, err := makeRaw(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer restore()
+
+	if _, err := f.WriteString(seq); err != nil {
+		return nil, err
+	}
+
+	// Terminals opened in non-blocking mode honor the read deadline, others
+	// return empty reads after a tenth of a second without input.
+	deadline := time.Now().Add(timeout)
+	f.SetReadDeadline(deadline)
+
+	var reply []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if complete(reply) {
+			return reply, nil
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) || time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: terminal didn't reply", ErrUnavailable)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+}
+
+// makeRaw disables echo and line buffering on the terminal, and makes reads
+// time out after a tenth of a second. It returns a function restoring the
+// previous state.
+func makeRaw(f *os.File) (func(), error) {
+	fd := f.Fd()
+
+	var old syscall.Termios
+	if err := ioctlTermios(fd, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON
+	raw.Cc[syscall.VMIN] = 0
+	raw.Cc[syscall.VTIME] = 1
+	if err := ioctlTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	return func() { ioctlTermios(fd, ioctlSetTermios, &old) }, nil
+}
+
+func ioctlTermios(fd uintptr, req uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// newTerminalBackend picks the best clipboard protocol the controlling
+// terminal supports: kitty's OSC 5522 if the terminal answers its query, and
+// OSC 52 otherwise.
+func newTerminalBackend() (backend, error) {
+	t := newTerminal()
+	f, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if t.supportsKitty() {
+		return kittyBackend{term: t}, nil
+	}
+	return osc52Backend{term: t}, nil
+}
+
+// da1Reply matches the reply to the primary device attributes query.
+var da1Reply = regexp.MustCompile(`\x1b\[\?[0-9;]*c`)
+
+// kittyProbes caches whether each terminal device implements OSC 5522, as
+// probing waits for the terminal to answer.
+var kittyProbes sync.Map
+
+// supportsKitty reports whether the terminal implements OSC 5522. The
+// result is cached for the terminal device.
+func (t terminal) supportsKitty() bool {
+	if ok, cached := kittyProbes.Load(t.path); cached {
+		return ok.(bool)
+	}
+	ok := t.probeKitty()
+	kittyProbes.Store(t.path, ok)
+	return ok
+}
+
+// probeKitty asks the terminal whether it implements OSC 5522. The query
+// for the available MIME types is followed by a device attributes query,
+// which every terminal answers: terminals without OSC 5522 only answer the
+// latter. Multiplexers answer the device attributes query themselves, so it
+// isn't wrapped. Without passthrough, tmux would swallow the query, so the
+// terminal isn't asked at all.
+func (t terminal) probeKitty() bool {
+	if t.mux == tmuxMultiplexer && !tmuxPassthrough() {
+		return false
+	}
+
+	seq := t.passthrough(kittyEncode([]byte(kittyList), "type", "read")) + "\x1b[c"
+	reply, err := t.exchange(seq, osc52Timeout, da1Reply.Match)
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(reply[:da1Reply.FindIndex(reply)[0]], []byte("\x1b]5522;"))
+}
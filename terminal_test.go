@@ -0,0 +1,158 @@
+//go:build linux && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// fakeTerminal is a pseudo terminal acting as a terminal emulator: every
+// OSC sequence written to its slave side is passed to a handler, whose
+// answer is sent back as input. Device attributes queries are answered too.
+type fakeTerminal struct {
+	// path is the slave device to give to the backends.
+	path string
+
+	mu     sync.Mutex
+	handle func(osc string) string
+}
+
+// newFakeTerminal creates a pseudo terminal answering OSC sequences with
+// handle. The sequences are passed without their introducer and terminator.
+func newFakeTerminal(t *testing.T, handle func(osc string) string) *fakeTerminal {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR, 0)
+	if err != nil {
+		t.Skipf("Pseudo terminals are unavailable: %v", err)
+	}
+	var unlock int32
+	if err := ptyIoctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		t.Fatalf("Failed to unlock pseudo terminal: %v", err)
+	}
+	var n uint32
+	if err := ptyIoctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		t.Fatalf("Failed to get pseudo terminal number: %v", err)
+	}
+
+	ft := &fakeTerminal{path: fmt.Sprintf("/dev/pts/%d", n), handle: handle}
+	// The device may have been probed by an earlier test.
+	kittyProbes.Delete(ft.path)
+
+	// Keep the slave side open so reads on the master don't fail between
+	// operations.
+	slave, err := os.OpenFile(ft.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", ft.path, err)
+	}
+
+	go ft.serve(master)
+	t.Cleanup(func() {
+		master.Close()
+		slave.Close()
+	})
+	return ft
+}
+
+func ptyIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setHandler replaces the handler of OSC sequences.
+func (ft *fakeTerminal) setHandler(handle func(osc string) string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.handle = handle
+}
+
+func (ft *fakeTerminal) serve(master *os.File) {
+	var in []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := master.Read(buf)
+		if err != nil {
+			return
+		}
+		in = append(in, buf[:n]...)
+
+		for {
+			start := bytes.Index(in, []byte("\x1b]"))
+			if da := bytes.Index(in, []byte("\x1b[c")); da >= 0 && (start < 0 || da < start) {
+				// Answer device attributes queries like a VT220.
+				master.WriteString("\x1b[?62;c")
+				in = in[da+3:]
+				continue
+			}
+			if start < 0 {
+				in = in[:0]
+				break
+			}
+			body := in[start+2:]
+			end, size := bytes.IndexByte(body, '\a'), 1
+			if st := bytes.Index(body, []byte("\x1b\\")); st >= 0 && (end < 0 || st < end) {
+				end, size = st, 2
+			}
+			if end < 0 {
+				in = in[start:]
+				break
+			}
+
+			ft.mu.Lock()
+			reply := ft.handle(string(body[:end]))
+			ft.mu.Unlock()
+			if reply != "" {
+				master.WriteString(reply)
+			}
+			in = body[end+size:]
+		}
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	seq := "\x1b]52;c;aGVsbG8=\x1b\\"
+	for _, tc := range []struct {
+		mux  multiplexer
+		want string
+	}{
+		{noMultiplexer, seq},
+		{tmuxMultiplexer, "\x1bPtmux;\x1b\x1b]52;c;aGVsbG8=\x1b\x1b\\\x1b\\"},
+		{screenMultiplexer, "\x1bP\x1b]52;c;aGVsbG8=\a\x1b\\"},
+	} {
+		if got := (terminal{mux: tc.mux}).passthrough(seq); got != tc.want {
+			t.Errorf("passthrough(%d) = %q, want %q", tc.mux, got, tc.want)
+		}
+	}
+
+	// screen gets long sequences in chunks.
+	long := "\x1b]52;c;" + strings.Repeat("A", 2*screenChunkSize) + "\a"
+	got := (terminal{mux: screenMultiplexer}).passthrough(long)
+	if n := strings.Count(got, "\x1bP"); n != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", n)
+	}
+	if unwrapped := strings.NewReplacer("\x1bP", "", "\x1b\\", "").Replace(got); unwrapped != long {
+		t.Fatalf("Chunks don't add up to the sequence: %q", unwrapped)
+	}
+}
+
+func TestNewTerminal(t *testing.T) {
+	t.Setenv("TMUX", "")
+	t.Setenv("STY", "1234.pts-0.host")
+	if mux := newTerminal().mux; mux != screenMultiplexer {
+		t.Fatalf("Expected screen, got %d", mux)
+	}
+	t.Setenv("TMUX", "/tmp/tmux-1000/default,1,0")
+	if mux := newTerminal().mux; mux != tmuxMultiplexer {
+		t.Fatalf("Expected tmux, got %d", mux)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// ErrInvalidText indicates that text is not valid UTF-8, reported when
+// [TextOptions.InvalidUTF8] is [RejectInvalidUTF8].
+var ErrInvalidText = errors.New("invalid UTF-8 text")
+
+// LineEndings selects how line endings are normalized.
+type LineEndings int
+
+const (
+	// KeepLineEndings leaves line endings as they are.
+	KeepLineEndings LineEndings = iota
+	// LF converts CRLF and lone CR line endings to LF.
+	LF
+	// CRLF converts LF and lone CR line endings to CRLF.
+	CRLF
+	// NativeLineEndings converts line endings to those of the platform,
+	// CRLF on Windows and LF elsewhere.
+	NativeLineEndings
+)
+
+// InvalidUTF8 selects what happens to text that isn't valid UTF-8.
+type InvalidUTF8 int
+
+const (
+	// KeepInvalidUTF8 leaves invalid UTF-8 as it is.
+	KeepInvalidUTF8 InvalidUTF8 = iota
+	// RejectInvalidUTF8 fails reads and writes with ErrInvalidText.
+	RejectInvalidUTF8
+	// RepairInvalidUTF8 replaces invalid bytes with U+FFFD.
+	RepairInvalidUTF8
+)
+
+// TextOptions normalizes the text read from and written to the clipboard in
+// the [Text] format. Other applications put text on the clipboard with
+// their platform's line endings, a byte order mark or a trailing NUL, and
+// may not care about valid UTF-8.
+//
+// The zero value leaves text untouched. Options apply to every backend,
+// but not to [Snapshot] and [Restore], which keep the native data as is.
+type TextOptions struct {
+	// LineEndings normalizes line endings.
+	LineEndings LineEndings
+	// StripBOM removes a leading UTF-8 byte order mark.
+	StripBOM bool
+	// TrimNUL removes trailing NUL bytes.
+	TrimNUL bool
+	// StripNUL removes every NUL byte. Writing text holding a NUL fails on
+	// Windows otherwise, as its clipboard text ends at the first NUL.
+	StripNUL bool
+	// InvalidUTF8 selects what happens to invalid UTF-8.
+	InvalidUTF8 InvalidUTF8
+}
+
+// defaultTextOptions holds the options set with SetTextOptions.
+var defaultTextOptions atomic.Pointer[TextOptions]
+
+// SetTextOptions sets how text is normalized by the package level API and
+// by clipboards created with [New] without [WithTextOptions].
+func SetTextOptions(o TextOptions) {
+	defaultTextOptions.Store(&o)
+}
+
+// bom is the UTF-8 encoded byte order mark.
+var bom = []byte("\xef\xbb\xbf")
+
+// apply normalizes text. The returned slice may share memory with text,
+// which is never modified.
+func (o TextOptions) apply(text []byte) ([]byte, error) {
+	if o.StripBOM {
+		text = bytes.TrimPrefix(text, bom)
+	}
+	if o.TrimNUL {
+		text = bytes.TrimRight(text, "\x00")
+	}
+	if o.StripNUL && bytes.IndexByte(text, 0) >= 0 {
+		text = bytes.ReplaceAll(text, []byte{0}, nil)
+	}
+
+	switch o.InvalidUTF8 {
+	case RejectInvalidUTF8:
+		if !utf8.Valid(text) {
+			return nil, ErrInvalidText
+		}
+	case RepairInvalidUTF8:
+		if !utf8.Valid(text) {
+			text = bytes.ToValidUTF8(text, []byte("\uFFFD"))
+		}
+	}
+
+	eol := o.LineEndings
+	if eol == NativeLineEndings {
+		eol = LF
+		if runtime.GOOS == "windows" {
+			eol = CRLF
+		}
+	}
+	if eol != KeepLineEndings && bytes.IndexByte(text, '\r') >= 0 {
+		text = bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n"))
+		text = bytes.ReplaceAll(text, []byte("\r"), []byte("\n"))
+	}
+	if eol == CRLF {
+		text = bytes.ReplaceAll(text, []byte("\n"), []byte("\r\n"))
+	}
+
+	return text, nil
+}
+
+// textOptions returns the options normalizing the clipboard's text.
+func (c *Clipboard) textOptions() TextOptions {
+	if c.text != nil {
+		return *c.text
+	}
+	if o := defaultTextOptions.Load(); o != nil {
+		return *o
+	}
+	return TextOptions{}
+}
+
+// normalize applies the text options to data in format f.
+func (c *Clipboard) normalize(f Format, data []byte) ([]byte, error) {
+	if f != Text {
+		return data, nil
+	}
+	return c.textOptions().apply(data)
+}
+
+// normalizeWatch applies the text options to the data received by a watch.
+// Data that fails normalization, or that doesn't change once normalized, is
+// dropped.
+func (c *Clipboard) normalizeWatch(ctx context.Context, f Format, in <-chan []byte) <-chan []byte {
+	if f != Text {
+		return in
+	}
+
+	out := make(chan []byte)
+	go func() {
+		defer close(out)
+
+		var last []byte
+		for {
+			var data []byte
+			select {
+			case buf, ok := <-in:
+				if !ok {
+					return
+				}
+				data = buf
+			case <-ctx.Done():
+				return
+			}
+
+			data, err := c.normalize(f, data)
+			if err != nil || (last != nil && bytes.Equal(data, last)) {
+				continue
+			}
+			last = data
+
+			select {
+			case out <- data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,131 @@
+package nativeclipboard
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTextOptionsApply(t *testing.T) {
+	native := "a\nb"
+	if runtime.GOOS == "windows" {
+		native = "a\r\nb"
+	}
+
+	tests := []struct {
+		name string
+		opts TextOptions
+		in   string
+		want string
+	}{
+		{"zero", TextOptions{}, "\xef\xbb\xbfa\r\nb\x00\xff", "\xef\xbb\xbfa\r\nb\x00\xff"},
+		{"lf", TextOptions{LineEndings: LF}, "a\r\nb\rc\nd", "a\nb\nc\nd"},
+		{"crlf", TextOptions{LineEndings: CRLF}, "a\r\nb\rc\nd", "a\r\nb\r\nc\r\nd"},
+		{"native", TextOptions{LineEndings: NativeLineEndings}, "a\r\nb", native},
+		{"bom", TextOptions{StripBOM: true}, "\xef\xbb\xbfa\xef\xbb\xbf", "a\xef\xbb\xbf"},
+		{"nul", TextOptions{TrimNUL: true}, "a\x00b\x00\x00", "a\x00b"},
+		{"strip nul", TextOptions{StripNUL: true}, "a\x00b\x00\x00", "ab"},
+		{"repair", TextOptions{InvalidUTF8: RepairInvalidUTF8}, "a\xff\xfeb", "a�b"},
+		{"all", TextOptions{LineEndings: LF, StripBOM: true, TrimNUL: true, InvalidUTF8: RepairInvalidUTF8}, "\xef\xbb\xbfa\r\n\xffb\x00", "a\n�b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.apply([]byte(tt.in))
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("Expected %q, got %q (%v)", tt.want, got, err)
+			}
+		})
+	}
+
+	if _, err := (TextOptions{InvalidUTF8: RejectInvalidUTF8}).apply([]byte("a\xff")); !errors.Is(err, ErrInvalidText) {
+		t.Fatalf("Expected ErrInvalidText, got %v", err)
+	}
+}
+
+func TestTextOptions(t *testing.T) {
+	c, err := New(WithBackend("memory"), WithTextOptions(TextOptions{
+		LineEndings: LF,
+		StripBOM:    true,
+		TrimNUL:     true,
+		InvalidUTF8: RejectInvalidUTF8,
+	}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Text written by another application.
+	if _, err := c.b.write(ClipboardSelection, Text, []byte("\xef\xbb\xbfone\r\ntwo\x00")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "one\ntwo" {
+		t.Fatalf("Expected %q, got %q (%v)", "one\ntwo", data, err)
+	}
+	if _, e, err := c.ReadAnyEntry(ClipboardSelection, Image, Text); err != nil || string(e.Data) != "one\ntwo" {
+		t.Fatalf("Expected %q, got %q (%v)", "one\ntwo", e.Data, err)
+	}
+
+	// Writes are normalized too, and images are left alone.
+	if _, err := c.Write(ClipboardSelection, Text, []byte("three\r\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _ := c.b.read(ClipboardSelection, Text); string(data) != "three\n" {
+		t.Fatalf("Expected %q written, got %q", "three\n", data)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("\xff")); !errors.Is(err, ErrInvalidText) {
+		t.Fatalf("Expected ErrInvalidText, got %v", err)
+	}
+	if _, err := c.Write(PrimarySelection, Image, []byte("\xef\xbb\xbf\r\n\x00")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _ := c.Read(PrimarySelection, Image); string(data) != "\xef\xbb\xbf\r\n\x00" {
+		t.Fatalf("Image was modified: %q", data)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := c.Watch(ctx, ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if _, err := c.b.write(ClipboardSelection, Text, []byte("four\r\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	select {
+	case data := <-ch:
+		if string(data) != "four\n" {
+			t.Fatalf("Expected %q, got %q", "four\n", data)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the watcher")
+	}
+}
+
+func TestSetTextOptions(t *testing.T) {
+	t.Cleanup(func() { SetTextOptions(TextOptions{}) })
+	SetTextOptions(TextOptions{LineEndings: CRLF})
+
+	c, err := New(WithBackend("memory"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("a\nb")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "a\r\nb" {
+		t.Fatalf("Expected %q, got %q (%v)", "a\r\nb", data, err)
+	}
+
+	// Options given to New take precedence.
+	c, err = New(WithBackend("memory"), WithTextOptions(TextOptions{}))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("a\nb")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "a\nb" {
+		t.Fatalf("Expected %q, got %q (%v)", "a\nb", data, err)
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// This file implements the client side of the Wayland wire protocol. Each
+// message starts with the id of the object it is sent to (or from), followed
+// by a word holding the message size in the upper 16 bits and the opcode in
+// the lower 16 bits. Arguments are 32-bit words in host byte order; strings
+// and arrays are length-prefixed and padded to 32 bits, and file descriptors
+// travel out of band as SCM_RIGHTS control messages.
+
+// wlDisplayID is the id of the wl_display singleton.
+const wlDisplayID = 1
+
+// wlMaxFDs is the maximum number of file descriptors accepted per read.
+const wlMaxFDs = 28
+
+// wlFD is a file descriptor argument.
+type wlFD int
+
+// wlHandler handles an event sent to an object.
+type wlHandler func(opcode uint16, d *wlDecoder)
+
+// wlEncode encodes a message. Arguments can be uint32 (uint, object and
+// new_id), int32, string, []byte (array) and wlFD.
+func wlEncode(id uint32, opcode uint16, args ...any) ([]byte, []int) {
+	buf := make([]byte, 8, 64)
+	var fds []int
+	for _, arg := range args {
+		switch v := arg.(type) {
+		case uint32:
+			buf = binary.NativeEndian.AppendUint32(buf, v)
+		case int32:
+			buf = binary.NativeEndian.AppendUint32(buf, uint32(v))
+		case string:
+			buf = binary.NativeEndian.AppendUint32(buf, uint32(len(v)+1))
+			buf = append(buf, v...)
+			buf = append(buf, make([]byte, pad4(len(v)+1)-len(v))...)
+		case []byte:
+			buf = binary.NativeEndian.AppendUint32(buf, uint32(len(v)))
+			buf = append(buf, v...)
+			buf = append(buf, make([]byte, pad4(len(v))-len(v))...)
+		case wlFD:
+			fds = append(fds, int(v))
+		default:
+			panic(fmt.Sprintf("wayland: unsupported argument type %T", arg))
+		}
+	}
+	binary.NativeEndian.PutUint32(buf[0:], id)
+	binary.NativeEndian.PutUint32(buf[4:], uint32(len(buf))<<16|uint32(opcode))
+	return buf, fds
+}
+
+// pad4 rounds n up to a multiple of 4.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// wlDecoder decodes the arguments of a single message.
+type wlDecoder struct {
+	data []byte
+	fds  *[]int
+	err  error
+}
+
+func (d *wlDecoder) word() uint32 {
+	if len(d.data) < 4 {
+		d.err = errors.New("wayland: message too short")
+		return 0
+	}
+	v := binary.NativeEndian.Uint32(d.data)
+	d.data = d.data[4:]
+	return v
+}
+
+func (d *wlDecoder) uint() uint32 { return d.word() }
+
+func (d *wlDecoder) int() int32 { return int32(d.word()) }
+
+func (d *wlDecoder) array() []byte {
+	n := int(d.word())
+	if pad4(n) > len(d.data) {
+		d.err = errors.New("wayland: array exceeds message")
+		return nil
+	}
+	v := d.data[:n]
+	d.data = d.data[pad4(n):]
+	return v
+}
+
+func (d *wlDecoder) string() string {
+	v := d.array()
+	if len(v) == 0 {
+		return ""
+	}
+	return string(v[:len(v)-1])
+}
+
+func (d *wlDecoder) fd() int {
+	if len(*d.fds) == 0 {
+		d.err = errors.New("wayland: missing file descriptor")
+		return -1
+	}
+	fd := (*d.fds)[0]
+	*d.fds = (*d.fds)[1:]
+	return fd
+}
+
+// wlConn is a client connection to a Wayland compositor. It is not safe for
+// concurrent use.
+type wlConn struct {
+	conn     *net.UnixConn
+	nextID   uint32
+	handlers map[uint32]wlHandler
+	in       []byte
+	fds      []int
+	err      error
+}
+
+// wlSocketPath returns the path of the compositor socket named by
+// $WAYLAND_DISPLAY.
+func wlSocketPath() (string, error) {
+	name := os.Getenv("WAYLAND_DISPLAY")
+	if name == "" {
+		name = "wayland-0"
+	}
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return "", fmt.Errorf("%w: XDG_RUNTIME_DIR is not set", ErrUnavailable)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// wlConnect connects to the compositor named by $WAYLAND_DISPLAY.
+func wlConnect() (*wlConn, error) {
+	path, err := wlSocketPath()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	c := &wlConn{
+		conn:     conn,
+		nextID:   wlDisplayID + 1,
+		handlers: make(map[uint32]wlHandler),
+	}
+	c.handlers[wlDisplayID] = func(opcode uint16, d *wlDecoder) {
+		switch opcode {
+		case 0: // error
+			id, code, msg := d.uint(), d.uint(), d.string()
+			c.err = fmt.Errorf("wayland: protocol error on object %d: %d: %s", id, code, msg)
+		case 1: // delete_id
+			delete(c.handlers, d.uint())
+		}
+	}
+	return c, nil
+}
+
+func (c *wlConn) close() {
+	c.conn.Close()
+	for _, fd := range c.fds {
+		syscall.Close(fd)
+	}
+	c.fds = nil
+}
+
+// newID allocates a new object id whose events are handled by h.
+func (c *wlConn) newID(h wlHandler) uint32 {
+	id := c.nextID
+	c.nextID++
+	if h == nil {
+		h = func(uint16, *wlDecoder) {}
+	}
+	c.handlers[id] = h
+	return id
+}
+
+// setHandler replaces the event handler of an object.
+func (c *wlConn) setHandler(id uint32, h wlHandler) {
+	c.handlers[id] = h
+}
+
+// send sends a request to an object.
+func (c *wlConn) send(id uint32, opcode uint16, args ...any) error {
+	if c.err != nil {
+		return c.err
+	}
+	buf, fds := wlEncode(id, opcode, args...)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err := c.conn.WriteMsgUnix(buf, oob, nil); err != nil {
+		c.err = err
+		return err
+	}
+	return nil
+}
+
+// dispatch reads from the connection, waiting until the deadline, and
+// handles all complete events received.
+func (c *wlConn) dispatch(deadline time.Time) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(wlMaxFDs*4))
+	c.conn.SetReadDeadline(deadline)
+	n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return err
+	}
+	c.in = append(c.in, buf[:n]...)
+
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			fds, err := syscall.ParseUnixRights(&m)
+			if err == nil {
+				c.fds = append(c.fds, fds...)
+			}
+		}
+	}
+
+	for len(c.in) >= 8 {
+		id := binary.NativeEndian.Uint32(c.in[0:])
+		word := binary.NativeEndian.Uint32(c.in[4:])
+		size, opcode := int(word>>16), uint16(word)
+		if size < 8 {
+			c.err = errors.New("wayland: invalid message size")
+			return c.err
+		}
+		if len(c.in) < size {
+			break
+		}
+
+		d := &wlDecoder{data: c.in[8:size], fds: &c.fds}
+		if h, ok := c.handlers[id]; ok {
+			h(opcode, d)
+		}
+		c.in = c.in[size:]
+		if c.err != nil {
+			return c.err
+		}
+	}
+
+	return nil
+}
+
+// roundtrip blocks until the compositor has processed all requests sent so
+// far, dispatching events meanwhile.
+func (c *wlConn) roundtrip(deadline time.Time) error {
+	done := false
+	callback := c.newID(func(opcode uint16, d *wlDecoder) {
+		done = true
+	})
+	if err := c.send(wlDisplayID, 0, callback); err != nil { // wl_display.sync
+		return err
+	}
+	return c.dispatchUntil(deadline, func() bool { return done })
+}
+
+// dispatchUntil dispatches events until cond returns true or the deadline
+// passes.
+func (c *wlConn) dispatchUntil(deadline time.Time, cond func() bool) error {
+	for !cond() {
+		if err := c.dispatch(deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wlGlobal is a global advertised by the compositor registry.
+type wlGlobal struct {
+	name    uint32
+	version uint32
+}
+
+// registry fetches the globals advertised by the compositor.
+func (c *wlConn) registry(deadline time.Time) (uint32, map[string]wlGlobal, error) {
+	globals := make(map[string]wlGlobal)
+	registry := c.newID(func(opcode uint16, d *wlDecoder) {
+		if opcode == 0 { // global
+			name, iface, version := d.uint(), d.string(), d.uint()
+			globals[iface] = wlGlobal{name: name, version: version}
+		}
+	})
+	if err := c.send(wlDisplayID, 1, registry); err != nil { // wl_display.get_registry
+		return 0, nil, err
+	}
+	if err := c.roundtrip(deadline); err != nil {
+		return 0, nil, err
+	}
+	return registry, globals, nil
+}
+
+// bind binds a global advertised by the registry, using at most version
+// maxVersion.
+func (c *wlConn) bind(registry uint32, iface string, g wlGlobal, maxVersion uint32, h wlHandler) (uint32, uint32) {
+	version := min(g.version, maxVersion)
+	id := c.newID(h)
+	c.send(registry, 0, g.name, iface, version, id) // wl_registry.bind
+	return id, version
+}
+
+// wlTimeout bounds how long we wait for the compositor and for other clients
+// to send data.
+const wlTimeout = 5 * time.Second
+
+// wlDeadline returns the deadline for an operation starting now.
+func wlDeadline() time.Time {
+	return time.Now().Add(wlTimeout)
+}
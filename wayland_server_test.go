@@ -0,0 +1,591 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+)
+
+// fakeCompositor is an in-process Wayland compositor implementing the
+// globals used for selections. Any surface that gets a buffer attached
+// receives keyboard focus right away, and data control devices follow the
+// selection regardless of focus.
+type fakeCompositor struct {
+	ln   *net.UnixListener
+	path string
+
+	mu      sync.Mutex
+	globals []fakeGlobal
+	clients map[*fakeWlClient]bool
+	serial  uint32
+	// selections holds the source of each selection.
+	selections [PrimarySelection + 1]*fakeWlSource
+	focus      *fakeWlClient
+}
+
+type fakeGlobal struct {
+	iface   string
+	version uint32
+}
+
+// fakeWlSource is the source of a selection. Sources without a client are
+// owned by the compositor itself and answer with data directly.
+type fakeWlSource struct {
+	client *fakeWlClient
+	id     uint32
+	iface  string
+	mimes  []string
+	data   map[string][]byte
+}
+
+// events returns the opcodes of the send and cancelled events of the source.
+func (src *fakeWlSource) events() (send, cancelled uint16) {
+	if src.iface == "wl_data_source" {
+		return 1, 2
+	}
+	return 0, 1
+}
+
+type fakeWlObject struct {
+	iface string
+	// surface links xdg surfaces to their wl_surface, and offers to their
+	// source.
+	surface uint32
+	source  *fakeWlSource
+	// buffer and configured track the state of surfaces.
+	buffer     bool
+	configured bool
+}
+
+type fakeWlClient struct {
+	srv     *fakeCompositor
+	conn    *net.UnixConn
+	objects map[uint32]*fakeWlObject
+	nextID  uint32
+	serial  uint32
+
+	wmu sync.Mutex
+}
+
+// fakeDataDeviceGlobals are the globals needed by the core data device
+// protocol.
+var fakeDataDeviceGlobals = []fakeGlobal{
+	{"wl_compositor", 4},
+	{"wl_shm", 1},
+	{"xdg_wm_base", 1},
+	{"wl_seat", 5},
+	{"wl_data_device_manager", 3},
+}
+
+// newFakeCompositor starts a compositor advertising globals, or
+// fakeDataDeviceGlobals if none are given, and points $WAYLAND_DISPLAY at it
+// for the duration of the test.
+func newFakeCompositor(t *testing.T, globals ...fakeGlobal) *fakeCompositor {
+	t.Helper()
+	if len(globals) == 0 {
+		globals = fakeDataDeviceGlobals
+	}
+
+	path := filepath.Join(t.TempDir(), "wayland-test")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	s := &fakeCompositor{
+		ln:      ln,
+		path:    path,
+		globals: globals,
+		clients: make(map[*fakeWlClient]bool),
+	}
+	go s.serve()
+	t.Cleanup(s.close)
+
+	t.Setenv("WAYLAND_DISPLAY", path)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	return s
+}
+
+func (s *fakeCompositor) serve() {
+	for {
+		conn, err := s.ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+		c := &fakeWlClient{
+			srv:     s,
+			conn:    conn,
+			objects: map[uint32]*fakeWlObject{wlDisplayID: {iface: "wl_display"}},
+			nextID:  0xff000000,
+		}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+		go c.serve()
+	}
+}
+
+func (s *fakeCompositor) close() {
+	s.ln.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// setSelection makes the compositor own the clipboard with the given data
+// keyed by MIME type, as if another application had copied it.
+func (s *fakeCompositor) setSelection(mimes []string, data map[string][]byte) {
+	s.setSelectionOf(ClipboardSelection, mimes, data)
+}
+
+// setSelectionOf is like setSelection for any selection.
+func (s *fakeCompositor) setSelectionOf(sel Selection, mimes []string, data map[string][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaceSelection(sel, &fakeWlSource{mimes: mimes, data: data})
+}
+
+// selectionMimes returns the MIME types offered by the clipboard.
+func (s *fakeCompositor) selectionMimes() []string {
+	return s.selectionMimesOf(ClipboardSelection)
+}
+
+// selectionMimesOf is like selectionMimes for any selection.
+func (s *fakeCompositor) selectionMimesOf(sel Selection) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.selections[sel] == nil {
+		return nil
+	}
+	return s.selections[sel].mimes
+}
+
+func (s *fakeCompositor) replaceSelection(sel Selection, src *fakeWlSource) {
+	if prev := s.selections[sel]; prev != nil && prev != src && prev.client != nil {
+		_, cancelled := prev.events()
+		prev.client.event(prev.id, cancelled)
+	}
+	s.selections[sel] = src
+	for c := range s.clients {
+		c.sendSelection("data_control_device", sel)
+	}
+	if s.focus != nil {
+		s.focus.sendSelection(fakeFocusDevices[sel], sel)
+	}
+}
+
+// fakeFocusDevices are the devices reporting each selection to the focused
+// client.
+var fakeFocusDevices = [...]string{
+	ClipboardSelection: "wl_data_device",
+	PrimarySelection:   "primary_selection_device",
+}
+
+func (c *fakeWlClient) serve() {
+	defer c.disconnect()
+
+	var in []byte
+	var fds []int
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(wlMaxFDs*4))
+	for {
+		n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		in = append(in, buf[:n]...)
+		if oobn > 0 {
+			msgs, _ := syscall.ParseSocketControlMessage(oob[:oobn])
+			for _, m := range msgs {
+				if rights, err := syscall.ParseUnixRights(&m); err == nil {
+					fds = append(fds, rights...)
+				}
+			}
+		}
+
+		for len(in) >= 8 {
+			id := binary.NativeEndian.Uint32(in[0:])
+			word := binary.NativeEndian.Uint32(in[4:])
+			size, opcode := int(word>>16), uint16(word)
+			if size < 8 {
+				return
+			}
+			if len(in) < size {
+				break
+			}
+			d := &wlDecoder{data: in[8:size], fds: &fds}
+			c.srv.mu.Lock()
+			c.handle(id, opcode, d)
+			c.srv.mu.Unlock()
+			in = in[size:]
+		}
+	}
+}
+
+func (c *fakeWlClient) disconnect() {
+	c.conn.Close()
+
+	s := c.srv
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+	if s.focus == c {
+		s.focus = nil
+	}
+	for sel, src := range s.selections {
+		if src != nil && src.client == c {
+			s.replaceSelection(Selection(sel), nil)
+		}
+	}
+}
+
+// event sends an event from an object to the client.
+func (c *fakeWlClient) event(id uint32, opcode uint16, args ...any) {
+	buf, fds := wlEncode(id, opcode, args...)
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.WriteMsgUnix(buf, oob, nil)
+}
+
+// create registers a client-allocated object.
+func (c *fakeWlClient) create(id uint32, iface string) *fakeWlObject {
+	o := &fakeWlObject{iface: iface}
+	c.objects[id] = o
+	return o
+}
+
+// destroy removes an object and acknowledges its id.
+func (c *fakeWlClient) destroy(id uint32) {
+	delete(c.objects, id)
+	c.event(wlDisplayID, 1, id) // wl_display.delete_id
+}
+
+// objectsOf returns the ids of all objects implementing iface.
+func (c *fakeWlClient) objectsOf(iface string) []uint32 {
+	var ids []uint32
+	for id, o := range c.objects {
+		if o.iface == iface {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// sendSelection advertises the current source of sel to the devices of the
+// client implementing iface.
+func (c *fakeWlClient) sendSelection(iface string, sel Selection) {
+	for _, device := range c.objectsOf(iface) {
+		c.sendSelectionTo(device, iface, sel)
+	}
+}
+
+func (c *fakeWlClient) sendSelectionTo(device uint32, iface string, sel Selection) {
+	var offerIface string
+	var selection uint16
+	switch iface {
+	case "wl_data_device":
+		offerIface, selection = "wl_data_offer", 5
+	case "primary_selection_device":
+		offerIface, selection = "primary_selection_offer", 1
+	case "data_control_device":
+		offerIface, selection = "data_control_offer", 1
+		if sel == PrimarySelection {
+			selection = 3 // primary_selection
+		}
+	}
+
+	src := c.srv.selections[sel]
+	if src == nil {
+		c.event(device, selection, uint32(0))
+		return
+	}
+	offer := c.nextID
+	c.nextID++
+	c.objects[offer] = &fakeWlObject{iface: offerIface, source: src}
+	c.event(device, 0, offer) // data_offer
+	for _, mime := range src.mimes {
+		c.event(offer, 0, mime) // offer
+	}
+	c.event(device, selection, offer)
+}
+
+// giveFocus moves keyboard focus to a surface of the client.
+func (c *fakeWlClient) giveFocus(surface uint32) {
+	s := c.srv
+	s.focus = c
+	s.serial++
+	c.serial = s.serial
+	for sel, iface := range fakeFocusDevices {
+		c.sendSelection(iface, Selection(sel))
+	}
+	for _, keyboard := range c.objectsOf("wl_keyboard") {
+		c.event(keyboard, 1, c.serial, surface, []byte{}) // wl_keyboard.enter
+	}
+}
+
+func (c *fakeWlClient) handle(id uint32, opcode uint16, d *wlDecoder) {
+	s := c.srv
+	o := c.objects[id]
+	if o == nil {
+		return
+	}
+
+	switch o.iface {
+	case "wl_display":
+		switch opcode {
+		case 0: // sync
+			callback := d.uint()
+			s.serial++
+			c.event(callback, 0, s.serial) // wl_callback.done
+			c.event(wlDisplayID, 1, callback)
+		case 1: // get_registry
+			registry := d.uint()
+			c.create(registry, "wl_registry")
+			for i, g := range s.globals {
+				c.event(registry, 0, uint32(i+1), g.iface, g.version) // wl_registry.global
+			}
+		}
+
+	case "wl_registry":
+		if opcode == 0 { // bind
+			name, iface, _, newID := d.uint(), d.string(), d.uint(), d.uint()
+			if name == 0 || int(name) > len(s.globals) || s.globals[name-1].iface != iface {
+				return
+			}
+			c.create(newID, iface)
+			if iface == "wl_seat" {
+				c.event(newID, 0, uint32(2)) // wl_seat.capabilities: keyboard
+			}
+		}
+
+	case "wl_compositor":
+		if opcode == 0 { // create_surface
+			c.create(d.uint(), "wl_surface")
+		}
+
+	case "wl_surface":
+		switch opcode {
+		case 0: // destroy
+			c.destroy(id)
+		case 1: // attach
+			o.buffer = d.uint() != 0
+		case 6: // commit
+			for xid, x := range c.objects {
+				if x.iface != "xdg_surface" || x.surface != id {
+					continue
+				}
+				if !x.configured {
+					x.configured = true
+					s.serial++
+					c.event(xid, 0, s.serial) // xdg_surface.configure
+				} else if o.buffer && s.focus != c {
+					c.giveFocus(id)
+				}
+			}
+		}
+
+	case "wl_shm":
+		if opcode == 0 { // create_pool
+			pool, fd := d.uint(), d.fd()
+			syscall.Close(fd)
+			c.create(pool, "wl_shm_pool")
+		}
+
+	case "wl_shm_pool":
+		switch opcode {
+		case 0: // create_buffer
+			c.create(d.uint(), "wl_buffer")
+		case 1: // destroy
+			c.destroy(id)
+		}
+
+	case "wl_buffer", "xdg_toplevel":
+		if opcode == 0 { // destroy
+			c.destroy(id)
+		}
+
+	case "xdg_wm_base":
+		if opcode == 2 { // get_xdg_surface
+			x := c.create(d.uint(), "xdg_surface")
+			x.surface = d.uint()
+		}
+
+	case "xdg_surface":
+		switch opcode {
+		case 0: // destroy
+			c.destroy(id)
+		case 1: // get_toplevel
+			c.create(d.uint(), "xdg_toplevel")
+		}
+
+	case "wl_seat":
+		if opcode == 1 { // get_keyboard
+			c.create(d.uint(), "wl_keyboard")
+		}
+
+	case "wl_data_device_manager":
+		switch opcode {
+		case 0: // create_data_source
+			c.create(d.uint(), "wl_data_source")
+		case 1: // get_data_device
+			c.create(d.uint(), "wl_data_device")
+		}
+
+	case "wl_data_source":
+		switch opcode {
+		case 0: // offer
+			if o.source == nil {
+				o.source = &fakeWlSource{client: c, id: id, iface: o.iface}
+			}
+			o.source.mimes = append(o.source.mimes, d.string())
+		case 1: // destroy
+			s.dropSource(o.source)
+			c.destroy(id)
+		}
+
+	case "wl_data_device":
+		if opcode == 1 { // set_selection
+			source, serial := d.uint(), d.uint()
+			// Only the focused client may set the selection.
+			if s.focus != c || serial != c.serial {
+				return
+			}
+			var src *fakeWlSource
+			if source != 0 {
+				so := c.objects[source]
+				if so == nil {
+					return
+				}
+				if so.source == nil {
+					so.source = &fakeWlSource{client: c, id: source, iface: so.iface}
+				}
+				src = so.source
+			}
+			s.replaceSelection(ClipboardSelection, src)
+		}
+
+	case "wl_data_offer":
+		switch opcode {
+		case 1: // receive
+			s.receive(o.source, d.string(), d.fd())
+		case 2: // destroy
+			c.destroy(id)
+		}
+
+	case "zwp_primary_selection_device_manager_v1":
+		switch opcode {
+		case 0: // create_source
+			source := d.uint()
+			o := c.create(source, "primary_selection_source")
+			o.source = &fakeWlSource{client: c, id: source, iface: o.iface}
+		case 1: // get_device
+			c.create(d.uint(), "primary_selection_device")
+		}
+
+	case "primary_selection_device":
+		if opcode == 0 { // set_selection
+			source, serial := d.uint(), d.uint()
+			if s.focus != c || serial != c.serial {
+				return
+			}
+			s.setSource(c, PrimarySelection, source)
+		}
+
+	case "ext_data_control_manager_v1", "zwlr_data_control_manager_v1":
+		switch opcode {
+		case 0: // create_data_source
+			source := d.uint()
+			o := c.create(source, "data_control_source")
+			o.source = &fakeWlSource{client: c, id: source, iface: o.iface}
+		case 1: // get_data_device
+			device := d.uint()
+			c.create(device, "data_control_device")
+			c.sendSelectionTo(device, "data_control_device", ClipboardSelection)
+			c.sendSelectionTo(device, "data_control_device", PrimarySelection)
+		}
+
+	case "data_control_source", "primary_selection_source":
+		switch opcode {
+		case 0: // offer
+			o.source.mimes = append(o.source.mimes, d.string())
+		case 1: // destroy
+			s.dropSource(o.source)
+			c.destroy(id)
+		}
+
+	case "data_control_device":
+		switch opcode {
+		case 0: // set_selection
+			s.setSource(c, ClipboardSelection, d.uint())
+		case 2: // set_primary_selection
+			s.setSource(c, PrimarySelection, d.uint())
+		}
+
+	case "data_control_offer", "primary_selection_offer":
+		switch opcode {
+		case 0: // receive
+			s.receive(o.source, d.string(), d.fd())
+		case 1: // destroy
+			c.destroy(id)
+		}
+	}
+}
+
+// setSource sets sel to the source object of the client with the given id,
+// or clears it if id is 0.
+func (s *fakeCompositor) setSource(c *fakeWlClient, sel Selection, id uint32) {
+	var src *fakeWlSource
+	if id != 0 {
+		so := c.objects[id]
+		if so == nil || so.source == nil {
+			return
+		}
+		src = so.source
+	}
+	s.replaceSelection(sel, src)
+}
+
+// dropSource clears the selections set to a destroyed source.
+func (s *fakeCompositor) dropSource(src *fakeWlSource) {
+	for sel, cur := range s.selections {
+		if src != nil && cur == src {
+			s.replaceSelection(Selection(sel), nil)
+		}
+	}
+}
+
+// receive sends the data of src as mime to fd, asking the client owning src
+// to write it if there is one.
+func (s *fakeCompositor) receive(src *fakeWlSource, mime string, fd int) {
+	if fd < 0 {
+		return
+	}
+	if src == nil || (src != s.selections[ClipboardSelection] && src != s.selections[PrimarySelection]) {
+		syscall.Close(fd)
+		return
+	}
+	if src.client == nil {
+		f := os.NewFile(uintptr(fd), "pipe")
+		data := src.data[mime]
+		go func() {
+			defer f.Close()
+			f.Write(data)
+		}()
+		return
+	}
+	send, _ := src.events()
+	src.client.event(src.id, send, mime, wlFD(fd))
+	syscall.Close(fd)
+}
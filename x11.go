@@ -0,0 +1,1176 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// xDisplay is a connection to an X server, made through libX11 or by
+// speaking the X11 protocol directly. A connection is used by a single
+// goroutine at a time.
+//
+// Property data of format 32 is passed as 32-bit items in native byte order,
+// whatever the underlying representation.
+type xDisplay interface {
+	close()
+
+	// createWindow creates a 1x1 window on the root window, used as a
+	// requestor or selection owner. The window reports property changes.
+	createWindow() Window
+	// internAtom returns the atom named name. It returns None if the atom
+	// doesn't exist and onlyIfExists is set.
+	internAtom(name string, onlyIfExists bool) Atom
+	// atomName returns the name of an atom, or "" if it's invalid.
+	atomName(atom Atom) string
+
+	setSelectionOwner(sel Atom, owner Window, t Time)
+	selectionOwner(sel Atom) Window
+	convertSelection(sel, target, prop Atom, requestor Window, t Time)
+
+	// changeProperty replaces a window property. It fails if the data is
+	// too large to be sent in one request.
+	changeProperty(w Window, prop, typ Atom, format int, data []byte) error
+	// touchProperty appends nothing to a window property, which makes the
+	// server send a PropertyNotify event holding the current server time.
+	touchProperty(w Window, prop, typ Atom)
+	// getProperty returns the type, format and data of a window property.
+	// It returns false if the property can't be read.
+	getProperty(w Window, prop Atom) (Atom, int, []byte, bool)
+	deleteProperty(w Window, prop Atom)
+
+	// sendEvent sends a SelectionNotify event to the requestor.
+	sendEvent(ev xEvent)
+	// flush sends buffered requests to the server.
+	flush()
+	// nextEvent returns the next event, waiting until deadline if it isn't
+	// zero. It returns an error wrapping ErrUnavailable on timeout or if the
+	// connection is lost.
+	nextEvent(deadline time.Time) (xEvent, error)
+}
+
+// xEvent is an X event relevant to selections. Fields that don't apply to
+// the event type are zero.
+type xEvent struct {
+	typ  int
+	time Time
+	// owner is the selection owner of SelectionRequest and SelectionClear
+	// events.
+	owner Window
+	// requestor is the window that asked for a conversion.
+	requestor Window
+	// window is the window whose property changed in PropertyNotify events.
+	window    Window
+	selection Atom
+	target    Atom
+	property  Atom
+	// state is PropertyNewValue or PropertyDelete in PropertyNotify events.
+	state int
+}
+
+// xError is an error the X server reported for a request.
+type xError struct {
+	code   byte
+	opcode byte
+	// resource is the offending resource ID or value, if any.
+	resource uint32
+}
+
+// xErrorNames names the core protocol errors.
+var xErrorNames = [...]string{
+	1: "BadRequest", "BadValue", "BadWindow", "BadPixmap", "BadAtom",
+	"BadCursor", "BadFont", "BadMatch", "BadDrawable", "BadAccess", "BadAlloc",
+	"BadColor", "BadGC", "BadIDChoice", "BadName", "BadLength",
+	"BadImplementation",
+}
+
+func (e *xError) Error() string {
+	name := fmt.Sprintf("error %d", e.code)
+	if int(e.code) < len(xErrorNames) && xErrorNames[e.code] != "" {
+		name = xErrorNames[e.code]
+	}
+	return fmt.Sprintf("X %s for request %d on 0x%x", name, e.opcode, e.resource)
+}
+
+// errTimeout is returned when the X server or a selection owner doesn't
+// answer in time.
+var errTimeout = fmt.Errorf("%w: timed out waiting for the X server", ErrUnavailable)
+
+// errLostConnection is returned by operations on a display whose connection
+// was lost.
+var errLostConnection = fmt.Errorf("%w: lost connection to the X server", ErrUnavailable)
+
+// x11Backend implements the clipboard on top of an X server, following the
+// ICCCM selection conventions.
+type x11Backend struct {
+	// name is the name of the backend, used to start detached helpers
+	// with the same backend.
+	name string
+	// dial connects to the X server.
+	dial func() (xDisplay, error)
+	// display is the X display name, $DISPLAY if empty.
+	display string
+	// xauthority is the X authority file given in the options, if any.
+	xauthority string
+	// libX11 lists the paths given in the options to load libX11 from.
+	libX11 []string
+}
+
+// displayName returns the name of the X display.
+func (b x11Backend) displayName() string {
+	if b.display != "" {
+		return b.display
+	}
+	return os.Getenv("DISPLAY")
+}
+
+// formatTarget returns the target name a format is written as.
+func formatTarget(t Format) (string, error) {
+	switch t {
+	case Text:
+		return "UTF8_STRING", nil
+	case Image:
+		return "image/png", nil
+	default:
+		return "", ErrUnsupported
+	}
+}
+
+// selectionName returns the name of the atom identifying a selection.
+func selectionName(s Selection) string {
+	if s == PrimarySelection {
+		return "PRIMARY"
+	}
+	return "CLIPBOARD"
+}
+
+// supports reports true, X11 has both selections.
+func (x11Backend) supports(Selection) bool {
+	return true
+}
+
+func (b x11Backend) read(s Selection, t Format) ([]byte, error) {
+	_, e, err := b.readAny(s, []Format{t})
+	return e.Data, err
+}
+
+// serverTime returns the current server time. ICCCM forbids CurrentTime
+// when taking or converting a selection, as it makes requests race with
+// each other. The time comes from the PropertyNotify event caused by
+// appending nothing to a property of window.
+func serverTime(d xDisplay, window Window) (Time, error) {
+	prop := d.internAtom("GOLANG_DESIGN_TIMESTAMP", false)
+	d.touchProperty(window, prop, d.internAtom("STRING", false))
+	d.flush()
+
+	deadline := time.Now().Add(convertTimeout)
+	for {
+		ev, err := d.nextEvent(deadline)
+		if err != nil {
+			return CurrentTime, err
+		}
+		if ev.typ == PropertyNotify && ev.window == window && ev.property == prop {
+			return ev.time, nil
+		}
+	}
+}
+
+// convertTimeout bounds how long we wait for the selection owner to answer a
+// conversion request, or to send the next chunk of an incremental transfer.
+// It's a variable so tests can shorten it.
+var convertTimeout = 5 * time.Second
+
+// convertSelection asks the owner of sel to convert it to target and store
+// the result in prop on window, at server time t. It returns the type, format
+// and data of the converted property. Large data sent incrementally is
+// reassembled.
+func convertSelection(d xDisplay, window Window, sel, target, prop Atom, t Time) (Atom, int, []byte, error) {
+	d.convertSelection(sel, target, prop, window, t)
+	d.flush()
+
+	deadline := time.Now().Add(convertTimeout)
+	for {
+		ev, err := d.nextEvent(deadline)
+		if err != nil {
+			return None, 0, nil, err
+		}
+		if ev.typ != SelectionNotify || ev.selection != sel || ev.target != target {
+			continue
+		}
+		if ev.property == None || ev.property != prop {
+			return None, 0, nil, ErrUnavailable
+		}
+		break
+	}
+
+	typ, format, data, ok := d.getProperty(window, prop)
+	if !ok {
+		return None, 0, nil, ErrUnavailable
+	}
+	d.deleteProperty(window, prop)
+
+	if typ == d.internAtom("INCR", false) {
+		return receiveIncr(d, window, prop)
+	}
+	return typ, format, data, nil
+}
+
+// receiveIncr receives data sent with the INCR mechanism. The owner writes
+// each chunk to the property after we delete the previous one, and ends the
+// transfer with an empty chunk. Deleting the INCR property, which the caller
+// did, starts the transfer.
+func receiveIncr(d xDisplay, window Window, prop Atom) (Atom, int, []byte, error) {
+	var buf []byte
+	d.flush()
+	for {
+		deadline := time.Now().Add(convertTimeout)
+		for {
+			ev, err := d.nextEvent(deadline)
+			if err != nil {
+				return None, 0, nil, err
+			}
+			if ev.typ == PropertyNotify && ev.window == window && ev.property == prop && ev.state == PropertyNewValue {
+				break
+			}
+		}
+
+		typ, format, data, ok := d.getProperty(window, prop)
+		if !ok {
+			return None, 0, nil, ErrUnavailable
+		}
+		d.deleteProperty(window, prop)
+		d.flush()
+
+		if len(data) == 0 {
+			return typ, format, buf, nil
+		}
+		buf = append(buf, data...)
+	}
+}
+
+// atomList interprets property data of format 32 as a list of atoms.
+func atomList(data []byte) []Atom {
+	atoms := make([]Atom, len(data)/4)
+	for i := range atoms {
+		atoms[i] = Atom(binary.NativeEndian.Uint32(data[i*4:]))
+	}
+	return atoms
+}
+
+// atomData encodes a list of atoms as property data of format 32.
+func atomData(atoms []Atom) []byte {
+	data := make([]byte, len(atoms)*4)
+	for i, a := range atoms {
+		binary.NativeEndian.PutUint32(data[i*4:], uint32(a))
+	}
+	return data
+}
+
+func (b x11Backend) write(s Selection, t Format, buf []byte) (<-chan struct{}, error) {
+	atomType, err := formatTarget(t)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.own(s, []Entry{{Target: atomType, Data: buf}})
+}
+
+func (b x11Backend) readAny(s Selection, formats []Format) (Format, Entry, error) {
+	for _, f := range formats {
+		if _, ok := formatTargets[f]; !ok {
+			return 0, Entry{}, ErrUnsupported
+		}
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d, err := b.dial()
+	if err != nil {
+		return 0, Entry{}, err
+	}
+	defer d.close()
+
+	return readFormats(d, s, formats)
+}
+
+// formatTargets lists the targets each format is read from, in order of
+// preference. Text is read from the targets naming their encoding first;
+// bare text/plain is only ASCII, and TEXT leaves the encoding to the owner.
+var formatTargets = map[Format][]string{
+	Text:  {"UTF8_STRING", "text/plain;charset=utf-8", "COMPOUND_TEXT", "STRING", "text/plain", "TEXT"},
+	Image: {"image/png"},
+}
+
+// readFormats reads the first of the given formats that the selection
+// offers. Text is converted to UTF-8 from whichever target it was read.
+func readFormats(d xDisplay, s Selection, formats []Format) (Format, Entry, error) {
+	window := d.createWindow()
+	sel := d.internAtom(selectionName(s), false)
+	prop := d.internAtom("GOLANG_DESIGN_DATA", false)
+	targetsAtom := d.internAtom("TARGETS", false)
+
+	if d.selectionOwner(sel) == None {
+		return 0, Entry{}, ErrUnavailable
+	}
+	t, err := serverTime(d, window)
+	if err != nil {
+		return 0, Entry{}, err
+	}
+
+	// Ask the owner what it offers. Owners that don't answer TARGETS are
+	// tried with every target in order instead, unless they didn't answer
+	// at all.
+	var offered map[Atom]bool
+	_, format, data, err := convertSelection(d, window, sel, targetsAtom, prop, t)
+	if errors.Is(err, errTimeout) || errors.Is(err, errLostConnection) {
+		return 0, Entry{}, err
+	}
+	if err == nil && format == 32 {
+		offered = make(map[Atom]bool)
+		for _, target := range atomList(data) {
+			offered[target] = true
+		}
+	}
+
+	for _, f := range formats {
+		for _, name := range formatTargets[f] {
+			target := d.internAtom(name, true)
+			if target == None || (offered != nil && !offered[target]) {
+				continue
+			}
+
+			typ, _, data, err := convertSelection(d, window, sel, target, prop, t)
+			if errors.Is(err, errTimeout) || errors.Is(err, errLostConnection) {
+				return 0, Entry{}, err
+			}
+			if err != nil {
+				continue
+			}
+			if f == Text {
+				var ok bool
+				if data, ok = decodeText(d.atomName(typ), data); !ok {
+					continue
+				}
+			}
+			return f, Entry{Target: name, Data: data}, nil
+		}
+	}
+
+	return 0, Entry{}, ErrUnavailable
+}
+
+// decodeText converts text held in a property of the named type to UTF-8.
+// It reports false for types that don't hold text, which includes TEXT: an
+// owner converting to TEXT must answer with the type it chose.
+func decodeText(typ string, data []byte) ([]byte, bool) {
+	switch typ {
+	case "UTF8_STRING", "text/plain;charset=utf-8":
+		return data, true
+	case "STRING", "text/plain":
+		// text/plain without a charset is ASCII, which Latin-1 extends.
+		return decodeLatin1(data), true
+	case "COMPOUND_TEXT":
+		return decodeCompoundText(data), true
+	}
+	return nil, false
+}
+
+// metaTargets are targets that describe the selection rather than hold its
+// data. They are never captured in a snapshot. TEXT is included as its type
+// depends on the owner; restoring serves it again from the typed text targets.
+var metaTargets = map[string]bool{
+	"TARGETS":          true,
+	"TEXT":             true,
+	"MULTIPLE":         true,
+	"TIMESTAMP":        true,
+	"SAVE_TARGETS":     true,
+	"DELETE":           true,
+	"INSERT_SELECTION": true,
+	"INSERT_PROPERTY":  true,
+}
+
+// serverTypes are property types whose data names resources of the X server.
+var serverTypes = map[string]bool{
+	"ATOM":      true,
+	"ATOM_PAIR": true,
+	"BITMAP":    true,
+	"COLORMAP":  true,
+	"CURSOR":    true,
+	"DRAWABLE":  true,
+	"FONT":      true,
+	"PIXMAP":    true,
+	"VISUALID":  true,
+	"WINDOW":    true,
+}
+
+func (b x11Backend) snapshot(s Selection) (*Contents, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+
+	window := d.createWindow()
+	sel := d.internAtom(selectionName(s), false)
+	prop := d.internAtom("GOLANG_DESIGN_DATA", false)
+	targetsAtom := d.internAtom("TARGETS", false)
+
+	contents := &Contents{}
+	if d.selectionOwner(sel) == None {
+		return contents, nil
+	}
+	t, err := serverTime(d, window)
+	if err != nil {
+		return nil, err
+	}
+
+	_, format, data, err := convertSelection(d, window, sel, targetsAtom, prop, t)
+	if err != nil {
+		return nil, err
+	}
+	if format != 32 {
+		return nil, ErrUnavailable
+	}
+
+	targets := atomList(data)
+	seen := make(map[Atom]bool, len(targets))
+	for _, target := range targets {
+		if target == None || seen[target] {
+			continue
+		}
+		seen[target] = true
+
+		name := d.atomName(target)
+		if name == "" || metaTargets[name] {
+			continue
+		}
+
+		// Resources such as atoms and windows don't survive being moved to
+		// another owner or another X server.
+		typ, format, data, err := convertSelection(d, window, sel, target, prop, t)
+		if err != nil {
+			continue
+		}
+		typeName := d.atomName(typ)
+		if typeName == "" || serverTypes[typeName] {
+			continue
+		}
+		contents.Entries = append(contents.Entries, Entry{Target: name, Type: typeName, Format: format, Data: data})
+	}
+
+	return contents, nil
+}
+
+func (b x11Backend) restore(s Selection, c *Contents) (<-chan struct{}, error) {
+	return b.own(s, c.Entries)
+}
+
+// owner describes a selection owner run by this process.
+type owner struct {
+	window  Window
+	targets []Atom
+	// saved receives whether the clipboard manager saved our targets.
+	saved chan bool
+	// done is closed when we lose ownership of the selection.
+	done chan struct{}
+
+	// The following fields are only used by the goroutine serving the
+	// selection.
+	d    xDisplay
+	data map[Atom]ownedTarget
+	// time is the server time we took ownership at.
+	time Time
+
+	sel, managerSel                          Atom
+	targetsAtom, timestampAtom, multipleAtom Atom
+	xaAtom, integerAtom, atomPairAtom        Atom
+}
+
+// ownedTarget is the data an owner serves for a target.
+type ownedTarget struct {
+	// typ and format are the type and format of the property holding the
+	// data.
+	typ    Atom
+	format int
+	data   []byte
+}
+
+// textAliases are the targets text is also served under. encode returns the
+// type and data of the property for the text, nil encodes it as is.
+var textAliases = []struct {
+	target string
+	encode func(text []byte) (string, []byte)
+}{
+	{"UTF8_STRING", nil},
+	{"text/plain;charset=utf-8", nil},
+	// Without a charset, text/plain is read as Latin-1 too.
+	{"text/plain", func(text []byte) (string, []byte) {
+		return "text/plain", encodeLatin1(text)
+	}},
+	{"COMPOUND_TEXT", func(text []byte) (string, []byte) {
+		return "COMPOUND_TEXT", encodeCompoundText(text)
+	}},
+	{"STRING", func(text []byte) (string, []byte) {
+		return "STRING", encodeLatin1(text)
+	}},
+	// TEXT leaves the encoding to the owner. Like Xlib, pick STRING when
+	// the text fits and COMPOUND_TEXT otherwise, which requestors asking for
+	// TEXT understand.
+	{"TEXT", func(text []byte) (string, []byte) {
+		if isLatin1(text) {
+			return "STRING", encodeLatin1(text)
+		}
+		return "COMPOUND_TEXT", encodeCompoundText(text)
+	}},
+}
+
+// utf8Text returns the UTF-8 text held by entries, if any.
+func utf8Text(entries []Entry) ([]byte, bool) {
+	for _, e := range entries {
+		if e.Target == "UTF8_STRING" || e.Target == "text/plain;charset=utf-8" {
+			return e.Data, true
+		}
+	}
+	return nil, false
+}
+
+// selectionOwners holds, for each selection, the owner started by the
+// latest write, or nil if this process doesn't own the selection.
+type selectionOwners [PrimarySelection + 1]atomic.Pointer[owner]
+
+// displayState is what this process keeps about an X display.
+type displayState struct {
+	owners selectionOwners
+
+	mu sync.Mutex
+	// lost is the reason the connection was lost, nil while connected.
+	lost        error
+	subscribers map[chan ConnectionEvent]struct{}
+}
+
+var (
+	displaysMu sync.Mutex
+	// displays holds the state of each X display.
+	displays = make(map[string]*displayState)
+)
+
+// state returns the state kept about the display.
+func (b x11Backend) state() *displayState {
+	displaysMu.Lock()
+	defer displaysMu.Unlock()
+
+	name := b.displayName()
+	st, ok := displays[name]
+	if !ok {
+		st = &displayState{subscribers: make(map[chan ConnectionEvent]struct{})}
+		displays[name] = st
+	}
+	return st
+}
+
+// owners returns the selection owners run by this process on the display.
+func (b x11Backend) owners() *selectionOwners {
+	return &b.state().owners
+}
+
+// report records whether the display is reachable, err being the reason it
+// isn't, and tells subscribers when that changes.
+func (st *displayState) report(err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if (err == nil) == (st.lost == nil) {
+		return
+	}
+	st.lost = err
+	ev := ConnectionEvent{Connected: err == nil, Err: err}
+	for ch := range st.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (b x11Backend) connectionEvents(ctx context.Context) <-chan ConnectionEvent {
+	st := b.state()
+	ch := make(chan ConnectionEvent, 8)
+
+	st.mu.Lock()
+	st.subscribers[ch] = struct{}{}
+	st.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		st.mu.Lock()
+		delete(st.subscribers, ch)
+		st.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+// Reconnection delays double after each failed attempt, up to
+// maxReconnectDelay.
+const (
+	reconnectDelay    = 100 * time.Millisecond
+	maxReconnectDelay = 5 * time.Second
+)
+
+// errOwned is returned by acquire when reconnecting finds the selection
+// owned by another client.
+var errOwned = errors.New("selection owned by another client")
+
+// own takes ownership of the selection and serves the given entries, keyed
+// by their target names, until another client takes over.
+//
+// If the connection to the X server is lost, it reconnects and takes the
+// selection back, unless another client owns it by then.
+func (b x11Backend) own(s Selection, entries []Entry) (<-chan struct{}, error) {
+	if detached.Load() {
+		return b.ownDetached(s, entries)
+	}
+
+	st := b.state()
+	errCh := make(chan error, 1)
+	done := make(chan struct{}, 1)
+
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		o, err := b.acquire(s, entries, false)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		o.saved = make(chan bool, 1)
+		o.done = done
+		st.owners[s].Store(o)
+		st.report(nil)
+		errCh <- nil
+
+		for {
+			err := o.serve()
+			o.d.close()
+			if err == nil {
+				st.owners[s].CompareAndSwap(o, nil)
+				break
+			}
+
+			st.report(err)
+			if o = b.reconnect(s, entries, o); o == nil {
+				break
+			}
+			st.report(nil)
+		}
+		close(done)
+	}()
+
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// acquire connects to the X server and takes ownership of the selection.
+// When reconnecting, it fails with errOwned rather than taking the selection
+// from another client.
+func (b x11Backend) acquire(s Selection, entries []Entry, reconnecting bool) (*owner, error) {
+	d, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	o := &owner{
+		d:             d,
+		window:        d.createWindow(),
+		data:          make(map[Atom]ownedTarget, len(entries)),
+		sel:           d.internAtom(selectionName(s), false),
+		managerSel:    d.internAtom("CLIPBOARD_MANAGER", false),
+		targetsAtom:   d.internAtom("TARGETS", false),
+		timestampAtom: d.internAtom("TIMESTAMP", false),
+		multipleAtom:  d.internAtom("MULTIPLE", false),
+		xaAtom:        d.internAtom("ATOM", false),
+		integerAtom:   d.internAtom("INTEGER", false),
+		atomPairAtom:  d.internAtom("ATOM_PAIR", false),
+	}
+	for _, e := range entries {
+		target := d.internAtom(e.Target, false)
+		if target == None {
+			continue
+		}
+		if _, ok := o.data[target]; !ok {
+			o.targets = append(o.targets, target)
+		}
+		typ := target
+		if e.Type != "" {
+			typ = d.internAtom(e.Type, false)
+		}
+		o.data[target] = ownedTarget{typ: typ, format: entryFormat(e), data: e.Data}
+	}
+
+	// Serve text under the other standard text targets too.
+	if text, ok := utf8Text(entries); ok {
+		for _, a := range textAliases {
+			target := d.internAtom(a.target, false)
+			if _, ok := o.data[target]; ok {
+				continue
+			}
+			typ, data := a.target, text
+			if a.encode != nil {
+				typ, data = a.encode(text)
+			}
+			o.targets = append(o.targets, target)
+			o.data[target] = ownedTarget{typ: d.internAtom(typ, false), format: 8, data: data}
+		}
+	}
+
+	if reconnecting && d.selectionOwner(o.sel) != None {
+		d.close()
+		return nil, errOwned
+	}
+	if o.time, err = serverTime(d, o.window); err != nil {
+		d.close()
+		return nil, err
+	}
+	d.setSelectionOwner(o.sel, o.window, o.time)
+	if d.selectionOwner(o.sel) != o.window {
+		d.close()
+		return nil, ErrUnavailable
+	}
+	return o, nil
+}
+
+// reconnect waits for the X server to come back after the connection of o
+// was lost, and takes the selection back. It returns the new owner, or nil
+// if o isn't the latest owner anymore or another client took the selection.
+func (b x11Backend) reconnect(s Selection, entries []Entry, o *owner) *owner {
+	owners := b.owners()
+	for delay := reconnectDelay; ; delay = min(2*delay, maxReconnectDelay) {
+		time.Sleep(delay)
+		if owners[s].Load() != o {
+			return nil
+		}
+
+		next, err := b.acquire(s, entries, true)
+		if errors.Is(err, errOwned) {
+			owners[s].CompareAndSwap(o, nil)
+			return nil
+		}
+		if err != nil {
+			continue
+		}
+
+		next.saved = o.saved
+		next.done = o.done
+		if !owners[s].CompareAndSwap(o, next) {
+			next.d.close()
+			return nil
+		}
+		return next
+	}
+}
+
+// convert stores the selection converted to target in a property of the
+// requestor, and reports whether it could.
+func (o *owner) convert(requestor Window, target, prop Atom) bool {
+	if prop == None {
+		return false
+	}
+
+	var err error
+	if t, ok := o.data[target]; ok {
+		err = o.d.changeProperty(requestor, prop, t.typ, t.format, t.data)
+	} else if target == o.targetsAtom {
+		targets := append([]Atom{o.targetsAtom, o.timestampAtom, o.multipleAtom}, o.targets...)
+		err = o.d.changeProperty(requestor, prop, o.xaAtom, 32, atomData(targets))
+	} else if target == o.timestampAtom {
+		err = o.d.changeProperty(requestor, prop, o.integerAtom, 32, binary.NativeEndian.AppendUint32(nil, uint32(o.time)))
+	} else {
+		return false
+	}
+	return err == nil
+}
+
+// convertMultiple answers a MULTIPLE request. The property holds pairs of
+// targets and properties to convert to, and the pairs that failed get their
+// property replaced with None, as ICCCM requires.
+func (o *owner) convertMultiple(requestor Window, prop Atom) bool {
+	if prop == None {
+		return false
+	}
+	_, format, data, ok := o.d.getProperty(requestor, prop)
+	if !ok || format != 32 {
+		return false
+	}
+
+	pairs := atomList(data)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		// MULTIPLE can't be nested.
+		if pairs[i] == o.multipleAtom || !o.convert(requestor, pairs[i], pairs[i+1]) {
+			pairs[i+1] = None
+		}
+	}
+	return o.d.changeProperty(requestor, prop, o.atomPairAtom, 32, atomData(pairs)) == nil
+}
+
+// serve answers requests for the selection until we lose it, and returns
+// an error if that's because the connection was lost.
+func (o *owner) serve() error {
+	d := o.d
+	for {
+		ev, err := d.nextEvent(time.Time{})
+		if err != nil {
+			return err
+		}
+
+		switch ev.typ {
+		case SelectionClear:
+			return nil
+
+		case SelectionNotify:
+			// The clipboard manager answered our SAVE_TARGETS request made
+			// by flush.
+			if ev.selection != o.managerSel {
+				continue
+			}
+			select {
+			case o.saved <- ev.property != None:
+			default:
+			}
+
+		case SelectionRequest:
+			if ev.selection != o.sel {
+				continue
+			}
+
+			reply := xEvent{
+				typ:       SelectionNotify,
+				time:      ev.time,
+				requestor: ev.requestor,
+				selection: ev.selection,
+				target:    ev.target,
+				property:  ev.property,
+			}
+
+			switch {
+			case ev.time != CurrentTime && int32(uint32(ev.time)-uint32(o.time)) < 0:
+				// The request is for the selection as it was before we
+				// owned it.
+				reply.property = None
+			case ev.target == o.multipleAtom:
+				if !o.convertMultiple(ev.requestor, ev.property) {
+					reply.property = None
+				}
+			default:
+				if !o.convert(ev.requestor, ev.target, ev.property) {
+					reply.property = None
+				}
+			}
+
+			d.sendEvent(reply)
+			d.flush()
+		}
+	}
+}
+
+// watch polls the selection, and reports whether the X server can be
+// reached to connection event subscribers meanwhile.
+func (b x11Backend) watch(ctx context.Context, s Selection, t Format) (<-chan []byte, error) {
+	if _, ok := formatTargets[t]; !ok {
+		return nil, ErrUnsupported
+	}
+
+	st := b.state()
+	read := func(s Selection, t Format) ([]byte, error) {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		d, err := b.dial()
+		if err != nil {
+			st.report(err)
+			return nil, err
+		}
+		defer d.close()
+		st.report(nil)
+
+		_, e, err := readFormats(d, s, []Format{t})
+		return e.Data, err
+	}
+	return pollWatch(ctx, s, t, read), nil
+}
+
+// changeCount returns the window owning the selection, which changes with
+// every write as each owner uses its own window.
+func (b x11Backend) changeCount(s Selection) (uint64, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	d, err := b.dial()
+	if err != nil {
+		return 0, err
+	}
+	defer d.close()
+
+	return uint64(d.selectionOwner(d.internAtom(selectionName(s), false))), nil
+}
+
+// flush hands the clipboard contents over to the clipboard manager using the
+// freedesktop clipboard manager protocol: we convert the CLIPBOARD_MANAGER
+// selection to SAVE_TARGETS on behalf of our owner window, and the manager
+// fetches our targets before answering.
+func (b x11Backend) flush(ctx context.Context) (bool, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, flushTimeout)
+		defer cancel()
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// Only hold the lock while asking the manager, not while it saves our
+	// targets.
+	lock.Lock()
+	o, d, manager, err := b.requestSave()
+	lock.Unlock()
+	if o == nil || err != nil {
+		return false, err
+	}
+	defer d.close()
+
+	select {
+	case ok := <-o.saved:
+		return ok, nil
+	case <-o.done:
+		// Managers usually take over the selection once they saved it.
+		return d.selectionOwner(d.internAtom("CLIPBOARD", false)) == manager, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// flushTimeout is how long flush waits for the clipboard manager when the
+// context has no deadline.
+const flushTimeout = 5 * time.Second
+
+// requestSave asks the clipboard manager to save the targets of our
+// clipboard owner. It returns a nil owner if there's nothing to save or no
+// manager to save it, and otherwise the connection the answer is checked on
+// and the manager window.
+func (b x11Backend) requestSave() (*owner, xDisplay, Window, error) {
+	o := b.owners()[ClipboardSelection].Load()
+	if o == nil {
+		return nil, nil, None, nil
+	}
+
+	d, err := b.dial()
+	if err != nil {
+		return nil, nil, None, err
+	}
+
+	managerSel := d.internAtom("CLIPBOARD_MANAGER", false)
+	saveTargets := d.internAtom("SAVE_TARGETS", false)
+	prop := d.internAtom("GOLANG_DESIGN_SAVE_TARGETS", false)
+	xaAtom := d.internAtom("ATOM", false)
+
+	manager := d.selectionOwner(managerSel)
+	if manager == None {
+		d.close()
+		return nil, nil, None, nil
+	}
+	t, err := serverTime(d, d.createWindow())
+	if err != nil {
+		d.close()
+		return nil, nil, None, err
+	}
+
+	// Drop a stale answer from an earlier flush.
+	select {
+	case <-o.saved:
+	default:
+	}
+
+	// The property on the requestor lists the targets the manager should
+	// save. The answer is delivered to the owner window, which keeps serving
+	// the manager's conversion requests meanwhile.
+	if len(o.targets) > 0 {
+		d.changeProperty(o.window, prop, xaAtom, 32, atomData(o.targets))
+	}
+	d.convertSelection(managerSel, saveTargets, prop, o.window, t)
+	d.flush()
+	return o, d, manager, nil
+}
+
+// helperArg is the only argument of the detached helper process started by
+// ownDetached, which also sets helperEnv to the name of the selection to own.
+// Both are needed, so that neither leaking into the environment nor the
+// command line of another process starts a helper. The helper uses the
+// backend named by backendEnv, and loads libX11 from the paths listed in
+// libX11Env first.
+const (
+	helperArg = "-nativeclipboard-helper"
+	helperEnv = "NATIVECLIPBOARD_HELPER"
+	libX11Env = "NATIVECLIPBOARD_LIBX11"
+)
+
+func init() {
+	if len(os.Args) != 2 || os.Args[1] != helperArg {
+		return
+	}
+	switch os.Getenv(helperEnv) {
+	case "":
+	case selectionName(PrimarySelection):
+		runHelper(PrimarySelection)
+	default:
+		runHelper(ClipboardSelection)
+	}
+}
+
+// runHelper is the entry point of the detached helper process. It reads an
+// archive of entries from stdin, takes ownership of the selection, reports
+// the owner window on stdout and serves the entries until another client
+// takes over. Failures are reported on stdout instead of the window. It
+// never returns.
+func runHelper(s Selection) {
+	fail := func(err error) {
+		fmt.Fprintln(os.Stdout, "error:", err)
+		os.Exit(1)
+	}
+
+	// Keep the variables away from the processes the binary may start.
+	name, libs := os.Getenv(backendEnv), filepath.SplitList(os.Getenv(libX11Env))
+	for _, env := range []string{helperEnv, backendEnv, libX11Env} {
+		os.Unsetenv(env)
+	}
+
+	c := newClipboard(WithBackend(name), WithLibX11(libs...))
+	if c.err != nil {
+		fail(c.err)
+	}
+	b, ok := c.b.(x11Backend)
+	if !ok {
+		fail(fmt.Errorf("%s is not an X11 backend", c.detection.Backend))
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fail(err)
+	}
+	var contents Contents
+	if err := contents.UnmarshalBinary(data); err != nil {
+		fail(err)
+	}
+
+	done, err := b.own(s, contents.Entries)
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Fprintln(os.Stdout, uint64(b.owners()[s].Load().window))
+	os.Stdout.Close()
+
+	<-done
+	os.Exit(0)
+}
+
+// ownDetached re-executes the current binary as a detached helper process
+// that owns the selection on our behalf, so the contents survive after we
+// exit. The helper connects to the same display with the same cookie.
+func (b x11Backend) ownDetached(s Selection, entries []Entry) (<-chan struct{}, error) {
+	archive, err := (&Contents{Entries: entries}).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find executable: %w", err)
+	}
+
+	cmd := exec.Command(exe, helperArg)
+	cmd.Env = append(os.Environ(), helperEnv+"="+selectionName(s), backendEnv+"="+b.name)
+	if b.display != "" {
+		cmd.Env = append(cmd.Env, "DISPLAY="+b.display)
+	}
+	if b.xauthority != "" {
+		cmd.Env = append(cmd.Env, "XAUTHORITY="+b.xauthority)
+	}
+	if len(b.libX11) > 0 {
+		cmd.Env = append(cmd.Env, libX11Env+"="+strings.Join(b.libX11, string(filepath.ListSeparator)))
+	}
+	cmd.Stdin = bytes.NewReader(archive)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start clipboard helper: %w", err)
+	}
+
+	// The helper answers with the owner window, or with the reason it
+	// failed.
+	line, _ := bufio.NewReader(out).ReadString('\n')
+	line = strings.TrimSpace(line)
+	window, err := strconv.ParseUint(line, 10, 32)
+	if err != nil {
+		cmd.Process.Kill()
+		werr := cmd.Wait()
+		if reason, ok := strings.CutPrefix(line, "error: "); ok {
+			return nil, fmt.Errorf("%w: clipboard helper failed: %s", ErrUnavailable, reason)
+		}
+		return nil, fmt.Errorf("%w: clipboard helper exited: %v", ErrUnavailable, werr)
+	}
+
+	// Reap the helper if it exits while we're still running.
+	go cmd.Wait()
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		d, err := b.dial()
+		if err != nil {
+			return
+		}
+		defer d.close()
+
+		sel := d.internAtom(selectionName(s), false)
+		for {
+			time.Sleep(time.Second)
+			if d.selectionOwner(sel) != Window(window) {
+				changed <- struct{}{}
+				close(changed)
+				return
+			}
+		}
+	}()
+
+	return changed, nil
+}
@@ -0,0 +1,844 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeXClipboards connects a clipboard to the fake X server through each X11
+// backend available.
+func fakeXClipboards(t *testing.T, display string, opts ...Option) map[string]*Clipboard {
+	t.Helper()
+
+	clipboards := make(map[string]*Clipboard)
+	for _, name := range []string{"x11", "xproto"} {
+		c, err := New(append([]Option{WithBackend(name), WithDisplay(display)}, opts...)...)
+		if err != nil {
+			if name == "xproto" {
+				t.Fatalf("New failed: %v", err)
+			}
+			continue
+		}
+		clipboards[name] = c
+	}
+	return clipboards
+}
+
+// shortConvertTimeout makes conversions time out quickly for the duration of
+// the test.
+func shortConvertTimeout(t *testing.T) {
+	timeout := convertTimeout
+	convertTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { convertTimeout = timeout })
+}
+
+func TestFakeXReadWrite(t *testing.T) {
+	_, display := startFakeX(t)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			changed, err := c.Write(ClipboardSelection, Text, []byte("Hermetic "+name))
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			data, err := c.Read(ClipboardSelection, Text)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if string(data) != "Hermetic "+name {
+				t.Fatalf("Expected %q, got %q", "Hermetic "+name, data)
+			}
+
+			snapshot, err := c.Snapshot(ClipboardSelection)
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			if len(snapshot.Entries) != len(textAliases)-1 || snapshot.Entries[0].Target != "UTF8_STRING" {
+				t.Fatalf("Unexpected snapshot %+v", snapshot.Entries)
+			}
+
+			if _, err := c.Write(PrimarySelection, Image, []byte("not a png")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			f, data, err := c.ReadAny(PrimarySelection, Text, Image)
+			if err != nil {
+				t.Fatalf("ReadAny failed: %v", err)
+			}
+			if f != Image || string(data) != "not a png" {
+				t.Fatalf("Expected the image, got %v %q", f, data)
+			}
+
+			if _, err := c.Restore(ClipboardSelection, &Contents{}); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			select {
+			case <-changed:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Timeout waiting for the first owner to lose ownership")
+			}
+		})
+	}
+}
+
+func TestFakeXMisbehavingOwners(t *testing.T) {
+	shortConvertTimeout(t)
+	s, display := startFakeX(t)
+	// Readers only ask for targets the server knows about.
+	s.mu.Lock()
+	s.atom("UTF8_STRING")
+	s.mu.Unlock()
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	owners := []struct {
+		name    string
+		handler func(r fakeXRequest)
+		want    []byte
+	}{
+		{"no reply", func(fakeXRequest) {}, nil},
+		{"refused", func(r fakeXRequest) { s.notify(r, None) }, nil},
+		{"wrong property", func(r fakeXRequest) {
+			s.setProperty(r.requestor, s.atom("OTHER"), r.target, 8, []byte("elsewhere"))
+			s.notify(r, s.atom("OTHER"))
+		}, nil},
+		{"incr", func(r fakeXRequest) { s.sendIncr(r, r.target, large, 1<<16) }, large},
+		{"stalled incr", func(r fakeXRequest) {
+			// Announce an INCR transfer that never starts.
+			s.setProperty(r.requestor, r.property, s.atom("INCR"), 32, []byte{0, 0, 1, 0})
+			s.notify(r, r.property)
+		}, nil},
+	}
+
+	for name, c := range fakeXClipboards(t, display) {
+		for _, o := range owners {
+			t.Run(name+"/"+o.name, func(t *testing.T) {
+				s.own("CLIPBOARD", o.handler)
+
+				data, err := c.Read(ClipboardSelection, Text)
+				if o.want == nil {
+					if !errors.Is(err, ErrUnavailable) {
+						t.Fatalf("Expected ErrUnavailable, got %v with %d bytes", err, len(data))
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Read failed: %v", err)
+				}
+				if !bytes.Equal(data, o.want) {
+					t.Fatalf("Expected %d bytes, got %d", len(o.want), len(data))
+				}
+			})
+		}
+	}
+}
+
+// TestFakeXDestroyedRequestor checks that an owner keeps serving after a
+// requestor went away before getting its answer, and the X errors it got
+// answering don't end the process.
+func TestFakeXDestroyedRequestor(t *testing.T) {
+	_, display := startFakeX(t)
+
+	d, err := dialXproto(display, nil)
+	if err != nil {
+		t.Fatalf("dialXproto failed: %v", err)
+	}
+	defer d.close()
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Still here")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			window := d.createWindow()
+			sel := d.internAtom("CLIPBOARD", false)
+			target := d.internAtom("UTF8_STRING", false)
+			prop := d.internAtom("GOLANG_DESIGN_DATA", false)
+			d.convertSelection(sel, target, prop, window, CurrentTime)
+			d.request(4, 0, values(uint32(window))) // DestroyWindow
+			d.selectionOwner(sel)
+
+			data, err := c.Read(ClipboardSelection, Text)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if string(data) != "Still here" {
+				t.Fatalf("Expected %q, got %q", "Still here", data)
+			}
+		})
+	}
+}
+
+func TestFakeXTimestamps(t *testing.T) {
+	s, display := startFakeX(t)
+	// Let the server clock move past the first timestamps.
+	time.Sleep(10 * time.Millisecond)
+
+	d, err := dialXproto(display, nil)
+	if err != nil {
+		t.Fatalf("dialXproto failed: %v", err)
+	}
+	defer d.close()
+	window := d.createWindow()
+	sel := d.internAtom("CLIPBOARD", false)
+	prop := d.internAtom("GOLANG_DESIGN_DATA", false)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("On time")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			s.mu.Lock()
+			owned := s.selections[s.atom("CLIPBOARD")].time
+			s.mu.Unlock()
+			if owned == CurrentTime {
+				t.Fatal("Selection owned at CurrentTime")
+			}
+
+			now, err := serverTime(d, window)
+			if err != nil {
+				t.Fatalf("serverTime failed: %v", err)
+			}
+			typ, format, data, err := convertSelection(d, window, sel, d.internAtom("TIMESTAMP", false), prop, now)
+			if err != nil {
+				t.Fatalf("Converting to TIMESTAMP failed: %v", err)
+			}
+			if typ != d.internAtom("INTEGER", false) || format != 32 || len(data) != 4 {
+				t.Fatalf("Unexpected TIMESTAMP reply of type %d, format %d: %v", typ, format, data)
+			}
+			if got := binary.NativeEndian.Uint32(data); got != owned {
+				t.Fatalf("Expected timestamp %d, got %d", owned, got)
+			}
+
+			_, _, data, err = convertSelection(d, window, sel, d.internAtom("TARGETS", false), prop, now)
+			if err != nil || !slices.Contains(atomList(data), d.internAtom("TIMESTAMP", false)) {
+				t.Fatalf("Expected TIMESTAMP in the targets, got %v (%v)", atomList(data), err)
+			}
+
+			// Requests made before we owned the selection are refused.
+			target := d.internAtom("UTF8_STRING", false)
+			if _, _, _, err := convertSelection(d, window, sel, target, prop, Time(owned-1)); !errors.Is(err, ErrUnavailable) {
+				t.Fatalf("Expected an old request to be refused, got %v", err)
+			}
+			if _, _, data, err := convertSelection(d, window, sel, target, prop, now); err != nil || string(data) != "On time" {
+				t.Fatalf("Expected %q, got %q (%v)", "On time", data, err)
+			}
+		})
+	}
+
+	// Readers convert at the server time too. The handler runs with s.mu
+	// held.
+	var requested uint32
+	s.own("CLIPBOARD", func(r fakeXRequest) {
+		requested = r.time
+		s.notify(r, None)
+	})
+	for name, c := range fakeXClipboards(t, display) {
+		s.mu.Lock()
+		requested = CurrentTime
+		s.mu.Unlock()
+		c.Read(ClipboardSelection, Text)
+		s.mu.Lock()
+		got := requested
+		s.mu.Unlock()
+		if got == CurrentTime {
+			t.Fatalf("%s converted the selection at CurrentTime", name)
+		}
+	}
+}
+
+func TestFakeXMultiple(t *testing.T) {
+	_, display := startFakeX(t)
+
+	d, err := dialXproto(display, nil)
+	if err != nil {
+		t.Fatalf("dialXproto failed: %v", err)
+	}
+	defer d.close()
+	window := d.createWindow()
+	sel := d.internAtom("CLIPBOARD", false)
+	prop := d.internAtom("GOLANG_DESIGN_MULTIPLE", false)
+	atom := func(name string) Atom { return d.internAtom(name, false) }
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Several "+name)); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			pairs := []Atom{
+				atom("UTF8_STRING"), atom("GOLANG_DESIGN_TEXT"),
+				atom("image/png"), atom("GOLANG_DESIGN_IMAGE"),
+				atom("TIMESTAMP"), atom("GOLANG_DESIGN_TIME"),
+				atom("MULTIPLE"), atom("GOLANG_DESIGN_NESTED"),
+			}
+			if err := d.changeProperty(window, prop, atom("ATOM_PAIR"), 32, atomData(pairs)); err != nil {
+				t.Fatalf("changeProperty failed: %v", err)
+			}
+			now, err := serverTime(d, window)
+			if err != nil {
+				t.Fatalf("serverTime failed: %v", err)
+			}
+			typ, format, data, err := convertSelection(d, window, sel, atom("MULTIPLE"), prop, now)
+			if err != nil {
+				t.Fatalf("Converting to MULTIPLE failed: %v", err)
+			}
+			if typ != atom("ATOM_PAIR") || format != 32 {
+				t.Fatalf("Unexpected MULTIPLE reply of type %d, format %d", typ, format)
+			}
+
+			// The pairs that failed have their property replaced with None.
+			want := slices.Clone(pairs)
+			want[3], want[7] = None, None
+			if got := atomList(data); !slices.Equal(got, want) {
+				t.Fatalf("Expected pairs %v, got %v", want, got)
+			}
+			if _, _, data, ok := d.getProperty(window, atom("GOLANG_DESIGN_TEXT")); !ok || string(data) != "Several "+name {
+				t.Fatalf("Expected %q, got %q", "Several "+name, data)
+			}
+			if typ, _, data, ok := d.getProperty(window, atom("GOLANG_DESIGN_TIME")); !ok || typ != atom("INTEGER") || len(data) != 4 {
+				t.Fatalf("Unexpected timestamp of type %d: %v", typ, data)
+			}
+		})
+	}
+}
+
+func TestFakeXTextAliases(t *testing.T) {
+	_, display := startFakeX(t)
+
+	d, err := dialXproto(display, nil)
+	if err != nil {
+		t.Fatalf("dialXproto failed: %v", err)
+	}
+	defer d.close()
+	window := d.createWindow()
+	sel := d.internAtom("CLIPBOARD", false)
+	prop := d.internAtom("GOLANG_DESIGN_DATA", false)
+	atom := func(name string) Atom { return d.internAtom(name, false) }
+	meta := []Atom{atom("TARGETS"), atom("TIMESTAMP"), atom("MULTIPLE")}
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			convert := func(target string) (Atom, []byte) {
+				t.Helper()
+				now, err := serverTime(d, window)
+				if err != nil {
+					t.Fatalf("serverTime failed: %v", err)
+				}
+				typ, _, data, err := convertSelection(d, window, sel, atom(target), prop, now)
+				if err != nil {
+					t.Fatalf("Converting to %s failed: %v", target, err)
+				}
+				return typ, data
+			}
+
+			if _, err := c.Write(ClipboardSelection, Image, []byte("not a png")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			_, data := convert("TARGETS")
+			if want := append(slices.Clone(meta), atom("image/png")); !slices.Equal(atomList(data), want) {
+				t.Fatalf("Expected targets %v, got %v", want, atomList(data))
+			}
+
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Grüße, €")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			_, data = convert("TARGETS")
+			want := slices.Clone(meta)
+			for _, a := range textAliases {
+				want = append(want, atom(a.target))
+			}
+			if !slices.Equal(atomList(data), want) {
+				t.Fatalf("Expected targets %v, got %v", want, atomList(data))
+			}
+
+			for _, tc := range []struct {
+				target, typ, data string
+			}{
+				{"UTF8_STRING", "UTF8_STRING", "Grüße, €"},
+				{"text/plain;charset=utf-8", "text/plain;charset=utf-8", "Grüße, €"},
+				{"text/plain", "text/plain", "Gr\xfc\xdfe, ?"},
+				{"COMPOUND_TEXT", "COMPOUND_TEXT", "Gr\xfc\xdfe, \x1b-b\xa4"},
+				{"STRING", "STRING", "Gr\xfc\xdfe, ?"},
+				{"TEXT", "COMPOUND_TEXT", "Gr\xfc\xdfe, \x1b-b\xa4"},
+			} {
+				typ, data := convert(tc.target)
+				if typ != atom(tc.typ) || string(data) != tc.data {
+					t.Errorf("%s: expected %s %q, got %s %q", tc.target, tc.typ, tc.data, d.atomName(typ), data)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeXTextNegotiation(t *testing.T) {
+	s, display := startFakeX(t)
+
+	type answer struct {
+		typ  string
+		data string
+	}
+	owners := []struct {
+		name    string
+		targets []string // nil refuses TARGETS
+		answers map[string]answer
+		want    Entry // the zero value expects ErrUnavailable
+	}{
+		{"utf-8 mime", []string{"text/plain;charset=utf-8"}, map[string]answer{
+			"text/plain;charset=utf-8": {"text/plain;charset=utf-8", "Grüße"},
+		}, Entry{Target: "text/plain;charset=utf-8", Data: []byte("Grüße")}},
+		{"latin-1", []string{"STRING"}, map[string]answer{
+			"STRING": {"STRING", "Gr\xfc\xdfe"},
+		}, Entry{Target: "STRING", Data: []byte("Grüße")}},
+		{"compound text", []string{"STRING", "COMPOUND_TEXT"}, map[string]answer{
+			"STRING":        {"STRING", "Gr??e"},
+			"COMPOUND_TEXT": {"COMPOUND_TEXT", "\x1b-L\xbf\xe0\xd8\xd2\xd5\xe2"},
+		}, Entry{Target: "COMPOUND_TEXT", Data: []byte("Привет")}},
+		{"text as latin-1", []string{"TEXT"}, map[string]answer{
+			"TEXT": {"STRING", "Gr\xfc\xdfe"},
+		}, Entry{Target: "TEXT", Data: []byte("Grüße")}},
+		{"text typed as text", []string{"TEXT"}, map[string]answer{
+			"TEXT": {"TEXT", "Grüße"},
+		}, Entry{}},
+		{"bare mime", []string{"text/plain", "TEXT"}, map[string]answer{
+			"text/plain": {"text/plain", "Gr\xfc\xdfe"},
+			"TEXT":       {"UTF8_STRING", "Grüße"},
+		}, Entry{Target: "text/plain", Data: []byte("Grüße")}},
+		{"preference", []string{"STRING", "UTF8_STRING"}, map[string]answer{
+			"STRING":      {"STRING", "Gr\xfc\xdfe"},
+			"UTF8_STRING": {"UTF8_STRING", "Grüße"},
+		}, Entry{Target: "UTF8_STRING", Data: []byte("Grüße")}},
+		{"no targets", nil, map[string]answer{
+			"STRING": {"STRING", "Gr\xfc\xdfe"},
+		}, Entry{Target: "STRING", Data: []byte("Grüße")}},
+		{"wrong type", []string{"UTF8_STRING", "STRING"}, map[string]answer{
+			"UTF8_STRING": {"image/png", "not text"},
+			"STRING":      {"STRING", "Gr\xfc\xdfe"},
+		}, Entry{Target: "STRING", Data: []byte("Grüße")}},
+	}
+
+	for name, c := range fakeXClipboards(t, display) {
+		for _, o := range owners {
+			t.Run(name+"/"+o.name, func(t *testing.T) {
+				s.own("CLIPBOARD", func(r fakeXRequest) {
+					if r.target == s.atom("TARGETS") && o.targets != nil {
+						var data []byte
+						for _, target := range o.targets {
+							data = binary.NativeEndian.AppendUint32(data, s.atom(target))
+						}
+						s.setProperty(r.requestor, r.property, s.atom("ATOM"), 32, data)
+						s.notify(r, r.property)
+						return
+					}
+					for target, a := range o.answers {
+						if r.target == s.atom(target) {
+							s.setProperty(r.requestor, r.property, s.atom(a.typ), 8, []byte(a.data))
+							s.notify(r, r.property)
+							return
+						}
+					}
+					s.notify(r, None)
+				})
+				// Owners refusing TARGETS only get asked for targets the
+				// server knows about.
+				s.mu.Lock()
+				for target := range o.answers {
+					s.atom(target)
+				}
+				s.mu.Unlock()
+
+				data, err := c.Read(ClipboardSelection, Text)
+				if o.want.Target == "" {
+					if !errors.Is(err, ErrUnavailable) {
+						t.Fatalf("Expected ErrUnavailable, got %q (%v)", data, err)
+					}
+					return
+				}
+				if err != nil || !bytes.Equal(data, o.want.Data) {
+					t.Fatalf("Expected %q, got %q (%v)", o.want.Data, data, err)
+				}
+				_, e, err := c.ReadAnyEntry(ClipboardSelection, Image, Text)
+				if err != nil || e.Target != o.want.Target || !bytes.Equal(e.Data, o.want.Data) {
+					t.Fatalf("Expected %+v, got %+v (%v)", o.want, e, err)
+				}
+			})
+		}
+	}
+}
+
+func TestFakeXBadAtom(t *testing.T) {
+	s, display := startFakeX(t)
+	s.own("CLIPBOARD", func(r fakeXRequest) {
+		// Offer an atom that doesn't exist.
+		s.setProperty(r.requestor, r.property, s.atom("ATOM"), 32, []byte{0xff, 0xff, 0xff, 0x0f})
+		s.notify(r, r.property)
+	})
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			snapshot, err := c.Snapshot(ClipboardSelection)
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+			if len(snapshot.Entries) != 0 {
+				t.Fatalf("Expected no entries, got %+v", snapshot.Entries)
+			}
+		})
+	}
+}
+
+func TestFakeXSnapshotTypes(t *testing.T) {
+	s, display := startFakeX(t)
+
+	type answer struct {
+		typ    string
+		format byte
+		data   []byte
+	}
+	answers := map[string]answer{
+		"COMPOUND_TEXT":        {"COMPOUND_TEXT", 8, []byte("\x1b-L\xbf\xe0\xd8\xd2\xd5\xe2")},
+		"TEXT":                 {"COMPOUND_TEXT", 8, []byte("\x1b-L\xbf\xe0\xd8\xd2\xd5\xe2")},
+		"application/x-counts": {"INTEGER", 32, binary.NativeEndian.AppendUint32(binary.NativeEndian.AppendUint32(nil, 1), 2)},
+		"x-window":             {"WINDOW", 32, binary.NativeEndian.AppendUint32(nil, 0x200001)},
+	}
+	targets := []string{"TARGETS", "TIMESTAMP", "COMPOUND_TEXT", "TEXT", "application/x-counts", "x-window"}
+	s.own("CLIPBOARD", func(r fakeXRequest) {
+		if r.target == s.atom("TARGETS") {
+			var data []byte
+			for _, target := range targets {
+				data = binary.NativeEndian.AppendUint32(data, s.atom(target))
+			}
+			s.setProperty(r.requestor, r.property, s.atom("ATOM"), 32, data)
+			s.notify(r, r.property)
+			return
+		}
+		for target, a := range answers {
+			if r.target == s.atom(target) {
+				s.setProperty(r.requestor, r.property, s.atom(a.typ), a.format, a.data)
+				s.notify(r, r.property)
+				return
+			}
+		}
+		s.notify(r, None)
+	})
+
+	want := []Entry{
+		{Target: "COMPOUND_TEXT", Type: "COMPOUND_TEXT", Format: 8, Data: answers["COMPOUND_TEXT"].data},
+		{Target: "application/x-counts", Type: "INTEGER", Format: 32, Data: answers["application/x-counts"].data},
+	}
+	check := func(t *testing.T, c *Clipboard) {
+		t.Helper()
+		snapshot, err := c.Snapshot(ClipboardSelection)
+		if err != nil {
+			t.Fatalf("Snapshot failed: %v", err)
+		}
+		if len(snapshot.Entries) != len(want) {
+			t.Fatalf("Expected %+v, got %+v", want, snapshot.Entries)
+		}
+		for i, e := range want {
+			got := snapshot.Entries[i]
+			if got.Target != e.Target || got.Type != e.Type || got.Format != e.Format || !bytes.Equal(got.Data, e.Data) {
+				t.Fatalf("Entry %d: expected %+v, got %+v", i, e, got)
+			}
+		}
+	}
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			check(t, c)
+			snapshot, err := c.Snapshot(ClipboardSelection)
+			if err != nil {
+				t.Fatalf("Snapshot failed: %v", err)
+			}
+
+			// The restored entries keep their type and format.
+			if _, err := c.Restore(ClipboardSelection, snapshot); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			check(t, c)
+		})
+	}
+}
+
+func TestFakeXTemporary(t *testing.T) {
+	s, display := startFakeX(t)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Original")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			err := c.WithTemporary(context.Background(), ClipboardSelection, Text, []byte("Temporary"), func() error {
+				data, err := c.Read(ClipboardSelection, Text)
+				if err != nil || string(data) != "Temporary" {
+					t.Errorf("Expected %q during the callback, got %q (%v)", "Temporary", data, err)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WithTemporary failed: %v", err)
+			}
+			if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "Original" {
+				t.Fatalf("Expected %q after restoring, got %q (%v)", "Original", data, err)
+			}
+
+			// Another client taking the selection right before fn returns
+			// keeps it, even though our owner may not have seen
+			// SelectionClear yet.
+			err = c.WithTemporary(context.Background(), ClipboardSelection, Text, []byte("Temporary"), func() error {
+				s.own("CLIPBOARD", func(r fakeXRequest) { s.notify(r, None) })
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("WithTemporary failed: %v", err)
+			}
+			s.mu.Lock()
+			handler := s.selections[s.atom("CLIPBOARD")].handler
+			s.mu.Unlock()
+			if handler == nil {
+				t.Fatal("Expected the other client to keep the selection")
+			}
+		})
+	}
+}
+
+// restartFakeX stops the fake X server and returns a new one on the same
+// address, which the caller starts serving.
+func restartFakeX(t *testing.T, s *fakeXServer) *fakeXServer {
+	t.Helper()
+
+	s.close()
+	ln, err := net.Listen("tcp", s.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	s = newFakeXServer(ln)
+	t.Cleanup(s.close)
+	return s
+}
+
+// waitConnection waits for a connection event.
+func waitConnection(t *testing.T, events <-chan ConnectionEvent, connected bool) {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		if ev.Connected != connected {
+			t.Fatalf("Expected connected=%v, got %+v", connected, ev)
+		}
+		if !connected && !errors.Is(ev.Err, ErrUnavailable) {
+			t.Fatalf("Expected ErrUnavailable, got %v", ev.Err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Timeout waiting for connected=%v", connected)
+	}
+}
+
+func TestFakeXLostConnection(t *testing.T) {
+	s, display := startFakeX(t)
+
+	for name, c := range fakeXClipboards(t, display) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := c.ConnectionEvents(ctx)
+			if err != nil {
+				t.Fatalf("ConnectionEvents failed: %v", err)
+			}
+
+			changed, err := c.Write(ClipboardSelection, Text, []byte("Back"))
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			// Drop every connection, the server keeps running.
+			s.mu.Lock()
+			for client := range s.clients {
+				client.conn.Close()
+			}
+			s.mu.Unlock()
+
+			waitConnection(t, events, false)
+			waitConnection(t, events, true)
+
+			data, err := c.Read(ClipboardSelection, Text)
+			if err != nil || string(data) != "Back" {
+				t.Fatalf("Expected %q after reconnecting, got %q (%v)", "Back", data, err)
+			}
+			select {
+			case <-changed:
+				t.Fatal("Ownership lost after reconnecting")
+			default:
+			}
+		})
+	}
+}
+
+func TestFakeXRestart(t *testing.T) {
+	for _, name := range []string{"x11", "xproto"} {
+		t.Run(name, func(t *testing.T) {
+			s, display := startFakeX(t)
+			c := fakeXClipboards(t, display)[name]
+			if c == nil {
+				t.Skip("libX11 is not available")
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := c.ConnectionEvents(ctx)
+			if err != nil {
+				t.Fatalf("ConnectionEvents failed: %v", err)
+			}
+
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Restarted")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			watch, err := c.Watch(ctx, PrimarySelection, Text)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+
+			s.close()
+			waitConnection(t, events, false)
+			// The server stays down for a few attempts.
+			time.Sleep(300 * time.Millisecond)
+			s = restartFakeX(t, s)
+			go s.serve()
+			waitConnection(t, events, true)
+
+			// The owner comes back with the data written before the restart,
+			// and the watcher sees the new server.
+			var data []byte
+			for range 50 {
+				if data, err = c.Read(ClipboardSelection, Text); err == nil {
+					break
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			if string(data) != "Restarted" {
+				t.Fatalf("Expected %q after the restart, got %q (%v)", "Restarted", data, err)
+			}
+			if _, err := c.Write(PrimarySelection, Text, []byte("Watched")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			select {
+			case data := <-watch:
+				if string(data) != "Watched" {
+					t.Fatalf("Expected %q, got %q", "Watched", data)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("Timeout waiting for the watcher")
+			}
+		})
+	}
+}
+
+// TestFakeXRestartTaken checks that an owner doesn't take the selection back
+// from a client that owned it while we were disconnected.
+func TestFakeXRestartTaken(t *testing.T) {
+	for _, name := range []string{"x11", "xproto"} {
+		t.Run(name, func(t *testing.T) {
+			s, display := startFakeX(t)
+			c := fakeXClipboards(t, display)[name]
+			if c == nil {
+				t.Skip("libX11 is not available")
+			}
+
+			changed, err := c.Write(ClipboardSelection, Text, []byte("Replaced"))
+			if err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			s = restartFakeX(t, s)
+			s.own("CLIPBOARD", func(r fakeXRequest) { s.notify(r, None) })
+			go s.serve()
+
+			select {
+			case <-changed:
+			case <-time.After(3 * time.Second):
+				t.Fatal("Timeout waiting for the owner to give up")
+			}
+		})
+	}
+}
+
+func TestFakeXCookie(t *testing.T) {
+	s, display := startFakeX(t)
+	cookie := []byte("0123456789abcdef")
+	s.mu.Lock()
+	s.cookie = cookie
+	s.mu.Unlock()
+
+	_, number, _ := parseDisplay(display)
+	good := writeXauthority(t, xauthEntry{xauthFamilyLocal, "localhost", number, xauthCookie, cookie})
+	bad := writeXauthority(t, xauthEntry{xauthFamilyLocal, "localhost", number, xauthCookie, []byte("fedcba9876543210")})
+	t.Setenv("XAUTHORITY", bad)
+
+	for name, c := range fakeXClipboards(t, display, WithXAuthority(good)) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := c.Write(ClipboardSelection, Text, []byte("Authorized")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if data, err := c.Read(ClipboardSelection, Text); err != nil || string(data) != "Authorized" {
+				t.Fatalf("Expected %q, got %q (%v)", "Authorized", data, err)
+			}
+		})
+	}
+
+	// Without an explicit file, the cookie comes from $XAUTHORITY.
+	if _, err := New(WithBackend("xproto"), WithDisplay(display)); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable with the wrong cookie, got %v", err)
+	}
+	os.Remove(bad)
+	if _, err := New(WithBackend("xproto"), WithDisplay(display)); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable without a cookie, got %v", err)
+	}
+}
+
+func FuzzFakeXSnapshot(f *testing.F) {
+	s, display := startFakeX(f)
+	c, err := New(WithBackend("xproto"), WithDisplay(display))
+	if err != nil {
+		f.Fatalf("New failed: %v", err)
+	}
+
+	// STRING is the predefined atom 31.
+	f.Add(byte(2), []byte{31, 0, 0, 0}, []byte("text"))
+	f.Add(byte(2), []byte{31, 0, 0, 0, 31, 0, 0, 0, 0xff, 0xff, 0xff, 0x7f}, []byte{})
+	f.Add(byte(0), []byte("not atoms"), []byte("text"))
+	f.Add(byte(1), []byte{31, 0}, []byte("text"))
+
+	f.Fuzz(func(t *testing.T, format byte, targets, data []byte) {
+		timeout := convertTimeout
+		convertTimeout = 50 * time.Millisecond
+		defer func() { convertTimeout = timeout }()
+
+		s.own("CLIPBOARD", func(r fakeXRequest) {
+			if r.target == s.atom("TARGETS") {
+				s.setProperty(r.requestor, r.property, s.atom("ATOM"), []byte{8, 16, 32}[format%3], targets)
+			} else {
+				s.setProperty(r.requestor, r.property, r.target, 8, data)
+			}
+			s.notify(r, r.property)
+		})
+
+		snapshot, err := c.Snapshot(ClipboardSelection)
+		if err != nil {
+			return
+		}
+		for _, e := range snapshot.Entries {
+			if !bytes.Equal(e.Data, data) {
+				t.Fatalf("Entry %q holds %q, expected %q", e.Target, e.Data, data)
+			}
+		}
+		c.ReadAny(ClipboardSelection, Text, Image)
+	})
+}
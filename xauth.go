@@ -0,0 +1,153 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Address families of X authority entries.
+const (
+	xauthFamilyInternet  = 0
+	xauthFamilyInternet6 = 6
+	xauthFamilyLocal     = 256
+	xauthFamilyWild      = 65535
+)
+
+// xauthCookie is the only authorization protocol we use.
+const xauthCookie = "MIT-MAGIC-COOKIE-1"
+
+// xauthEntry is an entry of an X authority file.
+type xauthEntry struct {
+	family  uint16
+	address string
+	number  string
+	name    string
+	data    []byte
+}
+
+// xauthorityPath returns the X authority file to use: path if set,
+// $XAUTHORITY otherwise, and ~/.Xauthority as a last resort.
+func xauthorityPath(path string) string {
+	if path != "" {
+		return path
+	}
+	if path := os.Getenv("XAUTHORITY"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".Xauthority")
+}
+
+// readXauthority parses an X authority file. Each entry is a big endian
+// family followed by the address, display number, protocol name and data,
+// each prefixed by its big endian length.
+func readXauthority(path string) ([]xauthEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	readField := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	var entries []xauthEntry
+	for {
+		var e xauthEntry
+		if err := binary.Read(r, binary.BigEndian, &e.family); err != nil {
+			if errors.Is(err, io.EOF) {
+				return entries, nil
+			}
+			return nil, err
+		}
+		var fields [4][]byte
+		for i := range fields {
+			if fields[i], err = readField(); err != nil {
+				return nil, fmt.Errorf("invalid X authority file %s: %w", path, err)
+			}
+		}
+		e.address, e.number, e.name, e.data = string(fields[0]), string(fields[1]), string(fields[2]), fields[3]
+		entries = append(entries, e)
+	}
+}
+
+// parseDisplay splits a display name such as "host:1.0" into its host and
+// display number. The host is empty for local displays.
+func parseDisplay(name string) (host, number string, err error) {
+	i := strings.LastIndexByte(name, ':')
+	if i < 0 {
+		return "", "", fmt.Errorf("%w: invalid display name %q", ErrUnavailable, name)
+	}
+	host, number = name[:i], name[i+1:]
+	if host == "unix" {
+		host = ""
+	}
+	number, _, _ = strings.Cut(number, ".")
+	if number == "" {
+		return "", "", fmt.Errorf("%w: invalid display name %q", ErrUnavailable, name)
+	}
+	return host, number, nil
+}
+
+// findXauth returns the cookie to connect to display, looking it up in the
+// given X authority entries like libXau does.
+func findXauth(entries []xauthEntry, display string) (*xauthEntry, error) {
+	host, number, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	// Local connections are recorded under the host name, and so are TCP
+	// connections to this host.
+	hostname, _ := os.Hostname()
+	local := host == "" || host == hostname || host == "localhost"
+	var addrs []net.IP
+	if !local {
+		if ip := net.ParseIP(host); ip != nil {
+			addrs = []net.IP{ip}
+		} else {
+			addrs, _ = net.LookupIP(host)
+		}
+	}
+
+	for i, e := range entries {
+		if e.name != xauthCookie || (e.number != "" && e.number != number) {
+			continue
+		}
+		switch e.family {
+		case xauthFamilyWild:
+			return &entries[i], nil
+		case xauthFamilyLocal:
+			if local && e.address == hostname || host != "" && e.address == host {
+				return &entries[i], nil
+			}
+		case xauthFamilyInternet, xauthFamilyInternet6:
+			for _, ip := range addrs {
+				if ip.Equal(net.IP(e.address)) {
+					return &entries[i], nil
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: no %s for display %q", ErrUnavailable, xauthCookie, display)
+}
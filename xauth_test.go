@@ -0,0 +1,79 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeXauthority writes an X authority file holding the given entries.
+func writeXauthority(t *testing.T, entries ...xauthEntry) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	field := func(b []byte) {
+		binary.Write(&buf, binary.BigEndian, uint16(len(b)))
+		buf.Write(b)
+	}
+	for _, e := range entries {
+		binary.Write(&buf, binary.BigEndian, e.family)
+		field([]byte(e.address))
+		field([]byte(e.number))
+		field([]byte(e.name))
+		field(e.data)
+	}
+
+	path := filepath.Join(t.TempDir(), "Xauthority")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestXauthority(t *testing.T) {
+	hostname, _ := os.Hostname()
+	path := writeXauthority(t,
+		xauthEntry{xauthFamilyLocal, hostname, "0", "XDM-AUTHORIZATION-1", []byte("xdm")},
+		xauthEntry{xauthFamilyLocal, hostname, "0", xauthCookie, []byte("local0")},
+		xauthEntry{xauthFamilyInternet, string([]byte{10, 0, 0, 2}), "1", xauthCookie, []byte("remote1")},
+		xauthEntry{xauthFamilyWild, "", "", xauthCookie, []byte("wild")},
+	)
+
+	entries, err := readXauthority(path)
+	if err != nil {
+		t.Fatalf("readXauthority failed: %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("Expected 4 entries, got %d", len(entries))
+	}
+
+	for _, tc := range []struct {
+		display string
+		want    string
+	}{
+		{":0", "local0"},
+		{"unix:0.0", "local0"},
+		{"10.0.0.2:1", "remote1"},
+		{":5", "wild"},
+	} {
+		e, err := findXauth(entries, tc.display)
+		if err != nil {
+			t.Fatalf("findXauth(%q) failed: %v", tc.display, err)
+		}
+		if string(e.data) != tc.want {
+			t.Fatalf("findXauth(%q) = %q, want %q", tc.display, e.data, tc.want)
+		}
+	}
+
+	if _, err := findXauth(entries[:3], "10.0.0.3:1"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable for an unknown host, got %v", err)
+	}
+	if _, err := findXauth(entries, "nonsense"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable for an invalid display, got %v", err)
+	}
+}
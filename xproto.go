@@ -0,0 +1,514 @@
+// Copyright 2025 Ayman Bagabas
+// SPDX-License-Identifier: MIT
+
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// X11 core protocol request opcodes.
+const (
+	xCreateWindowOp      = 1
+	xInternAtomOp        = 16
+	xGetAtomNameOp       = 17
+	xChangePropertyOp    = 18
+	xDeletePropertyOp    = 19
+	xGetPropertyOp       = 20
+	xSetSelectionOwnerOp = 22
+	xGetSelectionOwnerOp = 23
+	xConvertSelectionOp  = 24
+	xSendEventOp         = 25
+	xQueryExtensionOp    = 98
+)
+
+// xprotoDialTimeout bounds how long connecting to the X server may take.
+const xprotoDialTimeout = 5 * time.Second
+
+// newXprotoBackend returns a backend that speaks the X11 protocol directly
+// over the display socket, so it needs no system libraries.
+func newXprotoBackend(o *options) (backend, error) {
+	b := x11Backend{name: "xproto", display: o.display, xauthority: o.xauthority}
+	name := b.displayName()
+	if name == "" {
+		return nil, fmt.Errorf("%w: $DISPLAY is not set", ErrUnavailable)
+	}
+
+	auth, err := xprotoAuth(name, o.xauthority)
+	if err != nil {
+		return nil, err
+	}
+	b.dial = func() (xDisplay, error) {
+		c, err := dialXproto(name, auth)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+
+	d, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	d.close()
+
+	return b, nil
+}
+
+// xprotoAuth returns the cookie to connect to the display with. A missing
+// default X authority file isn't an error, since many servers don't require
+// one, but an explicit file must hold a cookie for the display.
+func xprotoAuth(display, xauthority string) (*xauthEntry, error) {
+	entries, err := readXauthority(xauthorityPath(xauthority))
+	if err != nil {
+		if xauthority != "" {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		return nil, nil
+	}
+	auth, err := findXauth(entries, display)
+	if err != nil && xauthority == "" {
+		return nil, nil
+	}
+	return auth, err
+}
+
+// xprotoConn is a connection to an X server speaking the core protocol. It
+// implements the few requests the clipboard needs.
+type xprotoConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// err is the first I/O error, after which the connection is unusable.
+	err error
+
+	// seq is the sequence number of the last request sent.
+	seq uint16
+	// events holds events read while waiting for a reply.
+	events []xEvent
+
+	ridBase, ridMask, rid uint32
+	// maxReqLen is the maximum request length in bytes.
+	maxReqLen int
+	// bigRequests is set when the BIG-REQUESTS extension is enabled.
+	bigRequests bool
+	root        Window
+}
+
+// dialXproto connects to the named X display and authenticates with auth, if
+// it's not nil.
+func dialXproto(name string, auth *xauthEntry) (*xprotoConn, error) {
+	host, number, err := parseDisplay(name)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid display name %q", ErrUnavailable, name)
+	}
+
+	var conn net.Conn
+	if host == "" {
+		// Local displays listen on a unix socket, and on Linux usually on
+		// an abstract socket of the same name too.
+		path := "/tmp/.X11-unix/X" + number
+		for _, addr := range []string{path, "@" + path} {
+			if conn, err = net.DialTimeout("unix", addr, xprotoDialTimeout); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			conn, err = net.DialTimeout("tcp", net.JoinHostPort("localhost", strconv.Itoa(6000+n)), xprotoDialTimeout)
+		}
+	} else {
+		conn, err = net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), xprotoDialTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to connect to X display %q: %v", ErrUnavailable, name, err)
+	}
+
+	c := &xprotoConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+	conn.SetDeadline(time.Now().Add(xprotoDialTimeout))
+	if err := c.setup(auth); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: X display %q: %v", ErrUnavailable, name, err)
+	}
+	conn.SetDeadline(time.Time{})
+	c.enableBigRequests()
+
+	return c, nil
+}
+
+// pad returns n rounded up to a multiple of 4, X11 aligns everything on
+// 4 bytes.
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// setup performs the connection handshake and reads the server information
+// we need.
+func (c *xprotoConn) setup(auth *xauthEntry) error {
+	var name string
+	var data []byte
+	if auth != nil {
+		name, data = auth.name, auth.data
+	}
+
+	req := make([]byte, 12+pad(len(name))+pad(len(data)))
+	req[0] = 'l'
+	if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+		req[0] = 'B'
+	}
+	binary.NativeEndian.PutUint16(req[2:], 11)
+	binary.NativeEndian.PutUint16(req[6:], uint16(len(name)))
+	binary.NativeEndian.PutUint16(req[8:], uint16(len(data)))
+	copy(req[12:], name)
+	copy(req[12+pad(len(name)):], data)
+	if _, err := c.conn.Write(req); err != nil {
+		return err
+	}
+
+	var hdr [8]byte
+	if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+		return err
+	}
+	body := make([]byte, int(binary.NativeEndian.Uint16(hdr[6:]))*4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+
+	switch hdr[0] {
+	case 0:
+		reason := body[:min(int(hdr[1]), len(body))]
+		return fmt.Errorf("connection refused: %s", reason)
+	case 1:
+	default:
+		return errors.New("connection refused: further authentication required")
+	}
+
+	if len(body) < 32 {
+		return errors.New("invalid connection setup")
+	}
+	c.ridBase = binary.NativeEndian.Uint32(body[4:])
+	c.ridMask = binary.NativeEndian.Uint32(body[8:])
+	vendorLen := int(binary.NativeEndian.Uint16(body[16:]))
+	c.maxReqLen = int(binary.NativeEndian.Uint16(body[18:])) * 4
+	numFormats := int(body[21])
+
+	// The first screen follows the vendor and pixmap formats, starting with
+	// its root window.
+	off := 32 + pad(vendorLen) + 8*numFormats
+	if off+4 > len(body) {
+		return errors.New("invalid connection setup")
+	}
+	c.root = Window(binary.NativeEndian.Uint32(body[off:]))
+	return nil
+}
+
+// enableBigRequests lifts the request size limit if the server supports the
+// BIG-REQUESTS extension, which large clipboard contents need.
+func (c *xprotoConn) enableBigRequests() {
+	const ext = "BIG-REQUESTS"
+	body := make([]byte, 4+pad(len(ext)))
+	binary.NativeEndian.PutUint16(body, uint16(len(ext)))
+	copy(body[4:], ext)
+	reply, err := c.roundTrip(xQueryExtensionOp, 0, body)
+	if err != nil || reply[8] == 0 {
+		return
+	}
+
+	reply, err = c.roundTrip(reply[9], 0, nil)
+	if err != nil {
+		return
+	}
+	c.maxReqLen = int(binary.NativeEndian.Uint32(reply[8:])) * 4
+	c.bigRequests = true
+}
+
+// request queues a request with the given body, which must be padded to
+// 4 bytes.
+func (c *xprotoConn) request(opcode, data byte, body []byte) {
+	n := (4 + len(body)) / 4
+	var hdr []byte
+	if n > 0xffff && c.bigRequests {
+		hdr = make([]byte, 8)
+		binary.NativeEndian.PutUint32(hdr[4:], uint32(n+1))
+	} else {
+		hdr = make([]byte, 4)
+		binary.NativeEndian.PutUint16(hdr[2:], uint16(n))
+	}
+	hdr[0], hdr[1] = opcode, data
+
+	c.seq++
+	if c.err != nil {
+		return
+	}
+	c.w.Write(hdr)
+	c.w.Write(body)
+}
+
+// roundTrip sends a request and waits for its reply, queuing the events
+// received meanwhile. The reply includes its 32-byte header.
+func (c *xprotoConn) roundTrip(opcode, data byte, body []byte) ([]byte, error) {
+	c.request(opcode, data, body)
+	seq := c.seq
+	c.flush()
+	c.conn.SetReadDeadline(time.Time{})
+
+	for {
+		p, err := c.readPacket()
+		if err != nil {
+			return nil, err
+		}
+		switch p[0] {
+		case 0:
+			if binary.NativeEndian.Uint16(p[2:]) == seq {
+				return nil, &xError{code: p[1], opcode: p[10], resource: binary.NativeEndian.Uint32(p[4:])}
+			}
+		case 1:
+			if binary.NativeEndian.Uint16(p[2:]) == seq {
+				return p, nil
+			}
+		default:
+			c.events = append(c.events, c.parseEvent(p))
+		}
+	}
+}
+
+// readPacket reads an error, reply or event.
+func (c *xprotoConn) readPacket() ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	p, err := c.r.Peek(32)
+	if err != nil {
+		return nil, c.fail(err)
+	}
+	n := 32
+	if p[0] == 1 {
+		n += int(binary.NativeEndian.Uint32(p[4:])) * 4
+	}
+
+	// Don't time out in the middle of a packet.
+	c.conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c.r, buf); err != nil {
+		return nil, c.fail(err)
+	}
+	return buf, nil
+}
+
+// fail records a connection error. Timeouts are returned as is, since the
+// connection stays usable.
+func (c *xprotoConn) fail(err error) error {
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return errTimeout
+	}
+	c.err = fmt.Errorf("%w: %v", errLostConnection, err)
+	return c.err
+}
+
+// parseEvent decodes the events relevant to selections.
+func (c *xprotoConn) parseEvent(p []byte) xEvent {
+	u32 := func(off int) uint32 { return binary.NativeEndian.Uint32(p[off:]) }
+	ev := xEvent{typ: int(p[0] & 0x7f)}
+	switch ev.typ {
+	case PropertyNotify:
+		ev.window = Window(u32(4))
+		ev.property = Atom(u32(8))
+		ev.time = Time(u32(12))
+		ev.state = int(p[16])
+	case SelectionClear:
+		ev.time = Time(u32(4))
+		ev.owner = Window(u32(8))
+		ev.selection = Atom(u32(12))
+	case SelectionRequest:
+		ev.time = Time(u32(4))
+		ev.owner = Window(u32(8))
+		ev.requestor = Window(u32(12))
+		ev.selection = Atom(u32(16))
+		ev.target = Atom(u32(20))
+		ev.property = Atom(u32(24))
+	case SelectionNotify:
+		ev.time = Time(u32(4))
+		ev.requestor = Window(u32(8))
+		ev.selection = Atom(u32(12))
+		ev.target = Atom(u32(16))
+		ev.property = Atom(u32(20))
+	}
+	return ev
+}
+
+// values encodes 32-bit request fields.
+func values(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.NativeEndian.PutUint32(b[i*4:], x)
+	}
+	return b
+}
+
+func (c *xprotoConn) close() {
+	c.conn.Close()
+}
+
+func (c *xprotoConn) createWindow() Window {
+	c.rid += c.ridMask & -c.ridMask
+	wid := c.ridBase | c.rid&c.ridMask
+
+	// x and y are 0, the size is 1x1, the border width 0 and the class and
+	// visual are copied from the parent. The only attribute set is the event
+	// mask.
+	const eventMaskAttr = 1 << 11
+	body := values(wid, uint32(c.root), 0, 1<<16|1, 0, 0, eventMaskAttr, PropertyChangeMask)
+	c.request(xCreateWindowOp, 0, body)
+	return Window(wid)
+}
+
+func (c *xprotoConn) internAtom(name string, onlyIfExists bool) Atom {
+	body := make([]byte, 4+pad(len(name)))
+	binary.NativeEndian.PutUint16(body, uint16(len(name)))
+	copy(body[4:], name)
+
+	var only byte
+	if onlyIfExists {
+		only = 1
+	}
+	reply, err := c.roundTrip(xInternAtomOp, only, body)
+	if err != nil {
+		return None
+	}
+	return Atom(binary.NativeEndian.Uint32(reply[8:]))
+}
+
+func (c *xprotoConn) atomName(atom Atom) string {
+	reply, err := c.roundTrip(xGetAtomNameOp, 0, values(uint32(atom)))
+	if err != nil {
+		return ""
+	}
+	n := int(binary.NativeEndian.Uint16(reply[8:]))
+	if 32+n > len(reply) {
+		return ""
+	}
+	return string(reply[32 : 32+n])
+}
+
+func (c *xprotoConn) setSelectionOwner(sel Atom, owner Window, t Time) {
+	c.request(xSetSelectionOwnerOp, 0, values(uint32(owner), uint32(sel), uint32(t)))
+}
+
+func (c *xprotoConn) selectionOwner(sel Atom) Window {
+	reply, err := c.roundTrip(xGetSelectionOwnerOp, 0, values(uint32(sel)))
+	if err != nil {
+		return None
+	}
+	return Window(binary.NativeEndian.Uint32(reply[8:]))
+}
+
+func (c *xprotoConn) convertSelection(sel, target, prop Atom, requestor Window, t Time) {
+	c.request(xConvertSelectionOp, 0, values(uint32(requestor), uint32(sel), uint32(target), uint32(prop), uint32(t)))
+}
+
+func (c *xprotoConn) changeProperty(w Window, prop, typ Atom, format int, data []byte) error {
+	size := 24 + pad(len(data))
+	if c.bigRequests {
+		size += 4
+	}
+	if size > c.maxReqLen {
+		return fmt.Errorf("%w: property of %d bytes exceeds the maximum request length", ErrUnavailable, len(data))
+	}
+
+	body := make([]byte, 20+pad(len(data)))
+	binary.NativeEndian.PutUint32(body[0:], uint32(w))
+	binary.NativeEndian.PutUint32(body[4:], uint32(prop))
+	binary.NativeEndian.PutUint32(body[8:], uint32(typ))
+	body[12] = byte(format)
+	binary.NativeEndian.PutUint32(body[16:], uint32(len(data)*8/format))
+	copy(body[20:], data)
+	c.request(xChangePropertyOp, PropModeReplace, body)
+	return nil
+}
+
+func (c *xprotoConn) touchProperty(w Window, prop, typ Atom) {
+	c.request(xChangePropertyOp, PropModeAppend, values(uint32(w), uint32(prop), uint32(typ), 8, 0))
+}
+
+func (c *xprotoConn) getProperty(w Window, prop Atom) (Atom, int, []byte, bool) {
+	reply, err := c.roundTrip(xGetPropertyOp, 0, values(uint32(w), uint32(prop), AnyPropertyType, 0, 0x3fffffff))
+	if err != nil {
+		return None, 0, nil, false
+	}
+
+	typ := Atom(binary.NativeEndian.Uint32(reply[8:]))
+	format := int(reply[1])
+	if typ == None {
+		return None, 0, nil, false
+	}
+	n := int(binary.NativeEndian.Uint32(reply[16:])) * format / 8
+	if 32+n > len(reply) {
+		return None, 0, nil, false
+	}
+	return typ, format, reply[32 : 32+n], true
+}
+
+func (c *xprotoConn) deleteProperty(w Window, prop Atom) {
+	c.request(xDeletePropertyOp, 0, values(uint32(w), uint32(prop)))
+}
+
+func (c *xprotoConn) sendEvent(ev xEvent) {
+	body := make([]byte, 40)
+	binary.NativeEndian.PutUint32(body[0:], uint32(ev.requestor))
+	e := body[8:]
+	e[0] = SelectionNotify
+	binary.NativeEndian.PutUint32(e[4:], uint32(ev.time))
+	binary.NativeEndian.PutUint32(e[8:], uint32(ev.requestor))
+	binary.NativeEndian.PutUint32(e[12:], uint32(ev.selection))
+	binary.NativeEndian.PutUint32(e[16:], uint32(ev.target))
+	binary.NativeEndian.PutUint32(e[20:], uint32(ev.property))
+	c.request(xSendEventOp, 0, body)
+}
+
+func (c *xprotoConn) flush() {
+	if c.err != nil {
+		return
+	}
+	if err := c.w.Flush(); err != nil {
+		c.fail(err)
+	}
+}
+
+func (c *xprotoConn) nextEvent(deadline time.Time) (xEvent, error) {
+	c.flush()
+	for {
+		if len(c.events) > 0 {
+			ev := c.events[0]
+			c.events = c.events[1:]
+			return ev, nil
+		}
+
+		c.conn.SetReadDeadline(deadline)
+		p, err := c.readPacket()
+		if err != nil {
+			return xEvent{}, err
+		}
+		// Errors and replies to requests we didn't wait for are dropped.
+		if p[0] > 1 {
+			return c.parseEvent(p), nil
+		}
+	}
+}
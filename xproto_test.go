@@ -0,0 +1,105 @@
+//go:build (linux || freebsd) && !android
+
+package nativeclipboard
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestXproto(t *testing.T) {
+	if os.Getenv("DISPLAY") == "" {
+		t.Skip("No X display")
+	}
+
+	c, err := New(WithBackend("xproto"))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if _, err := c.Write(ClipboardSelection, Text, []byte("Over the wire")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := c.Write(PrimarySelection, Text, []byte("Primary over the wire")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	data, err := c.Read(ClipboardSelection, Text)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "Over the wire" {
+		t.Fatalf("Expected %q, got %q", "Over the wire", data)
+	}
+
+	// The libX11 backend sees what the wire protocol backend serves.
+	x11, err := New(WithBackend("x11"))
+	if err != nil {
+		t.Skipf("No libX11: %v", err)
+	}
+	data, err = x11.Read(PrimarySelection, Text)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "Primary over the wire" {
+		t.Fatalf("Expected %q, got %q", "Primary over the wire", data)
+	}
+
+	// And the other way around, with contents larger than a request without
+	// BIG-REQUESTS.
+	image := make([]byte, 200000)
+	for i := range image {
+		image[i] = byte(i)
+	}
+	changed, err := x11.Write(ClipboardSelection, Image, image)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	snapshot, err := c.Snapshot(ClipboardSelection)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshot.Entries) != 1 || snapshot.Entries[0].Target != "image/png" || string(snapshot.Entries[0].Data) != string(image) {
+		t.Fatalf("Unexpected snapshot %+v", snapshot.Entries)
+	}
+
+	if _, err := c.Restore(ClipboardSelection, snapshot); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	select {
+	case <-changed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timeout waiting for the libX11 owner to lose ownership")
+	}
+	f, data, err := x11.ReadAny(ClipboardSelection, Text, Image)
+	if err != nil {
+		t.Fatalf("ReadAny failed: %v", err)
+	}
+	if f != Image || string(data) != string(image) {
+		t.Fatalf("Expected the image, got %v with %d bytes", f, len(data))
+	}
+}
+
+func TestXprotoAuth(t *testing.T) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		t.Skip("No X display")
+	}
+
+	empty := writeXauthority(t)
+	if _, err := New(WithBackend("xproto"), WithXAuthority(empty)); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable without a cookie, got %v", err)
+	}
+
+	hostname, _ := os.Hostname()
+	_, number, _ := parseDisplay(display)
+	xauthority := writeXauthority(t, xauthEntry{xauthFamilyLocal, hostname, number, xauthCookie, []byte("0123456789abcdef")})
+	if _, err := New(WithBackend("xproto"), WithXAuthority(xauthority)); err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if _, err := New(WithBackend("xproto"), WithDisplay(":4242")); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Expected ErrUnavailable for a missing display, got %v", err)
+	}
+}